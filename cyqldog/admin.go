@@ -0,0 +1,186 @@
+package cyqldog
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// AdminConfig is a configuration of the admin server.
+type AdminConfig struct {
+	// ListenAddress is an address to serve the admin endpoints. (e.g. ":8080")
+	// If empty, the admin server is disabled.
+	ListenAddress string `yaml:"listen_address"`
+}
+
+// enabled returns true if the listen address is set.
+func (c AdminConfig) enabled() bool {
+	return len(c.ListenAddress) > 0
+}
+
+// RuleStatus is the latest state of a rule served by the admin server.
+type RuleStatus struct {
+	// Name is the name of the rule.
+	Name string `json:"name"`
+	// DataSource is the name of the data source of the rule.
+	DataSource string `json:"data_source"`
+	// LastRun is when the last check started.
+	LastRun *time.Time `json:"last_run,omitempty"`
+	// LastDuration is how long the last check took in seconds.
+	LastDuration float64 `json:"last_duration_seconds"`
+	// Rows is the number of rows returned by the last check.
+	Rows int `json:"rows"`
+	// LastError is the error of the last check, or empty if it succeeded.
+	LastError string `json:"last_error,omitempty"`
+	// NextRun is when the rule is scheduled next.
+	NextRun *time.Time `json:"next_run,omitempty"`
+}
+
+// statusTracker keeps the last result and the next run of each rule for the status endpoint.
+type statusTracker struct {
+	mu    sync.Mutex
+	rules map[string]*RuleStatus
+}
+
+// newStatusTracker returns an instance of statusTracker.
+func newStatusTracker() *statusTracker {
+	return &statusTracker{
+		rules: make(map[string]*RuleStatus),
+	}
+}
+
+// get returns the status of the rule, creating it if not exists.
+// The caller must hold the lock.
+func (t *statusTracker) get(rule Rule) *RuleStatus {
+	s, ok := t.rules[rule.Name]
+	if !ok {
+		s = &RuleStatus{Name: rule.Name}
+		t.rules[rule.Name] = s
+	}
+	s.DataSource = rule.dataSourceName()
+	return s
+}
+
+// checked records the result of a check of the rule.
+func (t *statusTracker) checked(rule Rule, start time.Time, duration time.Duration, rows int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.get(rule)
+	s.LastRun = &start
+	s.LastDuration = duration.Seconds()
+	s.Rows = rows
+	s.LastError = ""
+	if err != nil {
+		s.LastError = err.Error()
+	}
+}
+
+// scheduled records the next scheduled time of the rule.
+func (t *statusTracker) scheduled(rule Rule, next time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.get(rule)
+	s.NextRun = &next
+}
+
+// remove forgets the status of the rule.
+func (t *statusTracker) remove(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.rules, name)
+}
+
+// list returns a copy of the statuses sorted by rule name.
+func (t *statusTracker) list() []RuleStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	statuses := make([]RuleStatus, 0, len(t.rules))
+	for _, s := range t.rules {
+		statuses = append(statuses, *s)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// startAdmin starts serving the admin endpoints in a new goroutine.
+// This function returns a error if it cannot listen on the address.
+func (m *Monitor) startAdmin(c AdminConfig) error {
+	l, err := net.Listen("tcp", c.ListenAddress)
+	if err != nil {
+		return xerrors.Errorf("failed to listen admin server: listen_address=%s: %w", c.ListenAddress, err)
+	}
+
+	m.admin = &http.Server{Handler: m.adminHandler()}
+	go func(s *http.Server) {
+		log.Printf("admin: serve: %s", l.Addr())
+		if err := s.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Printf("admin: failed to serve: %+v", err)
+		}
+	}(m.admin)
+
+	return nil
+}
+
+// stopAdmin stops serving the admin endpoints.
+func (m *Monitor) stopAdmin() {
+	if m.admin == nil {
+		return
+	}
+	if err := m.admin.Close(); err != nil {
+		log.Printf("admin: failed to close: %+v", err)
+	}
+}
+
+// adminHandler returns a handler of the admin endpoints.
+//
+//	/healthz returns 200 while the process is running.
+//	/readyz returns 200 after the data sources and the notifiers are set up, otherwise 503.
+//	/status returns the status of each rule in JSON.
+func (m *Monitor) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !m.ready.Load() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		body := struct {
+			Ready bool `json:"ready"`
+			// FailedEvents is the number of error events which could not be sent to their notifiers.
+			FailedEvents int64        `json:"failed_events"`
+			Rules        []RuleStatus `json:"rules"`
+		}{
+			Ready: m.ready.Load(),
+			Rules: m.status.list(),
+		}
+		if m.ready.Load() {
+			body.FailedEvents = m.delivery.failures()
+		}
+		if err := json.NewEncoder(w).Encode(body); err != nil {
+			log.Printf("admin: failed to write status: %+v", err)
+		}
+	})
+
+	return mux
+}
@@ -0,0 +1,81 @@
+package cyqldog
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+func TestMonitorAdminHandler(t *testing.T) {
+	m := NewMonitor("")
+	h := m.adminHandler()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	if rec := get("/healthz"); rec.Code != http.StatusOK {
+		t.Errorf("GET /healthz returns %d, want = %d", rec.Code, http.StatusOK)
+	}
+	if rec := get("/readyz"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz before ready returns %d, want = %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	// The delivery is set up before ready.
+	m.delivery = newEventDelivery(ErrorEventsConfig{}, Notifiers{}, InternalMetricsConfig{})
+	m.delivery.failed.Add(2)
+	m.ready.Store(true)
+	if rec := get("/readyz"); rec.Code != http.StatusOK {
+		t.Errorf("GET /readyz after ready returns %d, want = %d", rec.Code, http.StatusOK)
+	}
+
+	start := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	m.status.checked(Rule{Name: "test2", DataSource: "replica"}, start, 2*time.Second, 0, xerrors.New("query failed"))
+	m.status.checked(Rule{Name: "test1"}, start, time.Second, 3, nil)
+	m.status.scheduled(Rule{Name: "test1"}, start.Add(time.Minute))
+
+	rec := get("/status")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /status returns %d, want = %d", rec.Code, http.StatusOK)
+	}
+
+	var body struct {
+		Ready        bool         `json:"ready"`
+		FailedEvents int64        `json:"failed_events"`
+		Rules        []RuleStatus `json:"rules"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to parse /status: %s: %v", rec.Body.String(), err)
+	}
+	if !body.Ready {
+		t.Errorf("GET /status returns ready = false, want = true")
+	}
+	if body.FailedEvents != 2 {
+		t.Errorf("GET /status returns failed_events = %d, want = 2", body.FailedEvents)
+	}
+	if len(body.Rules) != 2 {
+		t.Fatalf("GET /status returns %d rules, want = 2", len(body.Rules))
+	}
+
+	s1 := body.Rules[0]
+	if s1.Name != "test1" || s1.DataSource != "default" || s1.Rows != 3 || s1.LastDuration != 1 || len(s1.LastError) > 0 {
+		t.Errorf("GET /status returns rules[0] = %+v", s1)
+	}
+	if s1.LastRun == nil || !s1.LastRun.Equal(start) {
+		t.Errorf("GET /status returns last_run = %v, want = %v", s1.LastRun, start)
+	}
+	if s1.NextRun == nil || !s1.NextRun.Equal(start.Add(time.Minute)) {
+		t.Errorf("GET /status returns next_run = %v, want = %v", s1.NextRun, start.Add(time.Minute))
+	}
+
+	s2 := body.Rules[1]
+	if s2.Name != "test2" || s2.DataSource != "replica" || s2.LastError != "query failed" || s2.NextRun != nil {
+		t.Errorf("GET /status returns rules[1] = %+v", s2)
+	}
+}
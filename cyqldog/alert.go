@@ -0,0 +1,232 @@
+package cyqldog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Alert is a threshold condition of a value column.
+type Alert struct {
+	// ValueCol is a name of the column in Rule.ValueCols to check.
+	ValueCol string `yaml:"value_col"`
+	// Direction is above or below. (default: above)
+	// The alert is triggered when the value is above (or below) the thresholds.
+	Direction string `yaml:"direction"`
+	// Warning is a threshold of the warning level.
+	Warning *float64 `yaml:"warning"`
+	// Critical is a threshold of the critical level.
+	Critical *float64 `yaml:"critical"`
+	// Recovery is a threshold to recover from the warning or critical level.
+	// If nil, the alert recovers as soon as the value does not cross the thresholds.
+	Recovery *float64 `yaml:"recovery"`
+}
+
+// Directions of alerts.
+const (
+	AlertAbove = "above"
+	AlertBelow = "below"
+)
+
+// Levels of alerts.
+const (
+	alertOK       = "ok"
+	alertWarning  = "warning"
+	alertCritical = "critical"
+)
+
+// direction returns the direction with the default applied.
+func (a Alert) direction() string {
+	if len(a.Direction) == 0 {
+		return AlertAbove
+	}
+	return a.Direction
+}
+
+// crosses returns true if the value is beyond the threshold.
+func (a Alert) crosses(value float64, threshold *float64) bool {
+	if threshold == nil {
+		return false
+	}
+	if a.direction() == AlertBelow {
+		return value < *threshold
+	}
+	return value > *threshold
+}
+
+// level returns the alert level of the value.
+// current is the level of the previous value, which is kept until recovery.
+func (a Alert) level(value float64, current string) string {
+	switch {
+	case a.crosses(value, a.Critical):
+		return alertCritical
+	case a.crosses(value, a.Warning):
+		return alertWarning
+	case current != alertOK && a.Recovery != nil && a.crosses(value, a.Recovery):
+		// Not recovered yet.
+		return current
+	default:
+		return alertOK
+	}
+}
+
+// threshold returns the threshold of the level.
+func (a Alert) threshold(level string) *float64 {
+	switch level {
+	case alertCritical:
+		return a.Critical
+	case alertWarning:
+		return a.Warning
+	default:
+		return a.Recovery
+	}
+}
+
+// alertTracker keeps the alert levels of each series to detect transitions.
+type alertTracker struct {
+	mu sync.Mutex
+	// levels is a map of rule names to the states of each series.
+	levels map[string]map[string]alertState
+}
+
+// alertState is the alert level of a series.
+type alertState struct {
+	level string
+	// metric is the last metric of the series to notify its disappearance.
+	metric metric
+}
+
+// newAlertTracker returns an instance of alertTracker.
+func newAlertTracker() *alertTracker {
+	return &alertTracker{
+		levels: make(map[string]map[string]alertState),
+	}
+}
+
+// evaluate checks the metrics of the rule against the alerts,
+// and returns events for the series whose levels have changed.
+// Series which disappear from the metrics are forgotten,
+// with a recovery event if they were in the warning or critical level.
+func (t *alertTracker) evaluate(rule Rule, metrics []metric) []*Event {
+	if len(rule.Alerts) == 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	prev := t.levels[rule.Name]
+	next := make(map[string]alertState)
+	events := []*Event{}
+
+	for _, m := range metrics {
+		for _, a := range rule.Alerts {
+			if m.name != rule.Name+"."+a.ValueCol {
+				continue
+			}
+
+			key := alertKey(m.name, m.tags)
+			current := alertOK
+			if s, ok := prev[key]; ok {
+				current = s.level
+			}
+
+			level := a.level(m.value, current)
+			next[key] = alertState{level: level, metric: m}
+
+			if level != current {
+				events = append(events, newAlertEvent(rule, a, m, current, level))
+			}
+		}
+	}
+
+	events = append(events, goneAlertEvents(rule, prev, next)...)
+
+	t.levels[rule.Name] = next
+	return events
+}
+
+// remove forgets the alert levels of the rule,
+// and returns recovery events for its series in the warning or critical level.
+func (t *alertTracker) remove(rule Rule) []*Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	events := goneAlertEvents(rule, t.levels[rule.Name], nil)
+	delete(t.levels, rule.Name)
+	return events
+}
+
+// goneAlertEvents returns recovery events for the series in prev which are not alerting in next.
+func goneAlertEvents(rule Rule, prev map[string]alertState, next map[string]alertState) []*Event {
+	// Sort the keys to send the events in a stable order.
+	gone := []string{}
+	for key, s := range prev {
+		if _, ok := next[key]; !ok && s.level != alertOK {
+			gone = append(gone, key)
+		}
+	}
+	sort.Strings(gone)
+
+	events := []*Event{}
+	for _, key := range gone {
+		events = append(events, newAlertGoneEvent(rule, prev[key].metric, prev[key].level))
+	}
+	return events
+}
+
+// alertKey returns a key to identify a series regardless of the order of tags.
+func alertKey(name string, tags []string) string {
+	sorted := append([]string{}, tags...)
+	sort.Strings(sorted)
+	return name + "{" + strings.Join(sorted, ",") + "}"
+}
+
+// newAlertEvent returns an event of the transition of the alert level.
+func newAlertEvent(rule Rule, a Alert, m metric, from string, to string) *Event {
+	// Map alert levels to event levels.
+	level := "success"
+	switch to {
+	case alertCritical:
+		level = "error"
+	case alertWarning:
+		level = "warning"
+	}
+
+	var title string
+	if to == alertOK {
+		title = fmt.Sprintf("cyqldog: [recovered] %s", m.name)
+	} else {
+		title = fmt.Sprintf("cyqldog: [%s] %s is %s %v", to, m.name, a.direction(), *a.threshold(to))
+	}
+
+	text := fmt.Sprintf("%s changed from %s to %s: value = %v, tags = %v", m.name, from, to, m.value, m.tags)
+
+	tags := []string{"cyqldog", "rule:" + rule.Name, "alert:" + to}
+	tags = append(tags, m.tags...)
+
+	return &Event{
+		Title:   title,
+		Text:    text,
+		Level:   level,
+		Tags:    tags,
+		Rule:    rule.Name,
+		Channel: rule.Channel,
+	}
+}
+
+// newAlertGoneEvent returns a recovery event of the series which disappeared from the metrics.
+func newAlertGoneEvent(rule Rule, m metric, from string) *Event {
+	tags := []string{"cyqldog", "rule:" + rule.Name, "alert:" + alertOK}
+	tags = append(tags, m.tags...)
+
+	return &Event{
+		Title:   fmt.Sprintf("cyqldog: [recovered] %s", m.name),
+		Text:    fmt.Sprintf("%s changed from %s to %s: series gone, tags = %v", m.name, from, alertOK, m.tags),
+		Level:   "success",
+		Tags:    tags,
+		Rule:    rule.Name,
+		Channel: rule.Channel,
+	}
+}
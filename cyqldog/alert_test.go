@@ -0,0 +1,146 @@
+package cyqldog
+
+import (
+	"reflect"
+	"testing"
+)
+
+// float64Ptr returns a pointer to the value for testing.
+func float64Ptr(v float64) *float64 {
+	return &v
+}
+
+func TestAlertTrackerEvaluate(t *testing.T) {
+	cases := []struct {
+		name   string
+		alert  Alert
+		values []float64
+		levels []string
+	}{
+		{
+			name:   "above",
+			alert:  Alert{ValueCol: "count", Warning: float64Ptr(500), Critical: float64Ptr(1000)},
+			values: []float64{100, 600, 700, 1100, 1200, 600, 100, 100},
+			levels: []string{"", "warning", "", "error", "", "warning", "success", ""},
+		},
+		{
+			name:   "below",
+			alert:  Alert{ValueCol: "count", Direction: AlertBelow, Critical: float64Ptr(10)},
+			values: []float64{20, 5, 15},
+			levels: []string{"", "error", "success"},
+		},
+		{
+			name:   "recovery",
+			alert:  Alert{ValueCol: "count", Critical: float64Ptr(1000), Recovery: float64Ptr(800)},
+			values: []float64{1100, 900, 850, 700, 900},
+			levels: []string{"error", "", "", "success", ""},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rule := Rule{
+				Name:      tc.name,
+				ValueCols: []string{"count"},
+				Alerts:    []Alert{tc.alert},
+			}
+			tracker := newAlertTracker()
+
+			levels := []string{}
+			for _, v := range tc.values {
+				metrics := []metric{{name: tc.name + ".count", value: v, tags: []string{"tag1:hoge"}}}
+				events := tracker.evaluate(rule, metrics)
+				switch len(events) {
+				case 0:
+					levels = append(levels, "")
+				case 1:
+					levels = append(levels, events[0].Level)
+				default:
+					t.Fatalf("alertTracker.evaluate(%v) returns %d events, want <= 1", v, len(events))
+				}
+			}
+
+			if !reflect.DeepEqual(levels, tc.levels) {
+				t.Errorf("alertTracker.evaluate(%v) returns events of\n got = %q,\nwant = %q", tc.values, levels, tc.levels)
+			}
+		})
+	}
+}
+
+func TestAlertTrackerEvaluatePerTags(t *testing.T) {
+	rule := Rule{
+		Name:      "test",
+		ValueCols: []string{"count"},
+		Alerts:    []Alert{{ValueCol: "count", Critical: float64Ptr(10)}},
+	}
+	tracker := newAlertTracker()
+
+	// Only the series crossing the threshold triggers an event.
+	events := tracker.evaluate(rule, []metric{
+		{name: "test.count", value: 20, tags: []string{"queue:a"}},
+		{name: "test.count", value: 5, tags: []string{"queue:b"}},
+	})
+	if len(events) != 1 || !reflect.DeepEqual(events[0].Tags, []string{"cyqldog", "rule:test", "alert:critical", "queue:a"}) {
+		t.Errorf("alertTracker.evaluate() returns events = %+v, want an event of queue:a", events)
+	}
+
+	// The level of queue:a is kept, and queue:b crosses the threshold.
+	events = tracker.evaluate(rule, []metric{
+		{name: "test.count", value: 20, tags: []string{"queue:a"}},
+		{name: "test.count", value: 15, tags: []string{"queue:b"}},
+	})
+	if len(events) != 1 || !reflect.DeepEqual(events[0].Tags, []string{"cyqldog", "rule:test", "alert:critical", "queue:b"}) {
+		t.Errorf("alertTracker.evaluate() returns events = %+v, want an event of queue:b", events)
+	}
+}
+
+func TestAlertTrackerEvaluateGone(t *testing.T) {
+	rule := Rule{
+		Name:      "test",
+		ValueCols: []string{"count"},
+		Alerts:    []Alert{{ValueCol: "count", Critical: float64Ptr(10)}},
+	}
+	tracker := newAlertTracker()
+
+	tracker.evaluate(rule, []metric{
+		{name: "test.count", value: 20, tags: []string{"queue:a"}},
+		{name: "test.count", value: 5, tags: []string{"queue:b"}},
+	})
+
+	// The critical series recovers when it disappears, but the ok one does not send an event.
+	events := tracker.evaluate(rule, []metric{})
+	if len(events) != 1 {
+		t.Fatalf("alertTracker.evaluate() returns %d events, want = 1: %+v", len(events), events)
+	}
+	if events[0].Level != "success" || !reflect.DeepEqual(events[0].Tags, []string{"cyqldog", "rule:test", "alert:ok", "queue:a"}) {
+		t.Errorf("alertTracker.evaluate() returns events = %+v, want a recovery event of queue:a", events)
+	}
+
+	// The series is forgotten, so it is alerted again when it comes back.
+	events = tracker.evaluate(rule, []metric{{name: "test.count", value: 20, tags: []string{"queue:a"}}})
+	if len(events) != 1 || events[0].Level != "error" {
+		t.Errorf("alertTracker.evaluate() returns events = %+v, want a critical event of queue:a", events)
+	}
+}
+
+func TestAlertTrackerRemove(t *testing.T) {
+	rule := Rule{
+		Name:      "test",
+		ValueCols: []string{"count"},
+		Alerts:    []Alert{{ValueCol: "count", Critical: float64Ptr(10)}},
+	}
+	tracker := newAlertTracker()
+	tracker.evaluate(rule, []metric{
+		{name: "test.count", value: 20, tags: []string{"queue:a"}},
+		{name: "test.count", value: 5, tags: []string{"queue:b"}},
+	})
+
+	// The critical series of the removed rule recovers.
+	events := tracker.remove(rule)
+	if len(events) != 1 || events[0].Level != "success" || !reflect.DeepEqual(events[0].Tags, []string{"cyqldog", "rule:test", "alert:ok", "queue:a"}) {
+		t.Errorf("alertTracker.remove() returns events = %+v, want a recovery event of queue:a", events)
+	}
+	if _, ok := tracker.levels[rule.Name]; ok {
+		t.Errorf("alertTracker.remove() keeps the levels = %+v", tracker.levels[rule.Name])
+	}
+}
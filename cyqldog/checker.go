@@ -1,13 +1,25 @@
 package cyqldog
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"time"
+
+	"golang.org/x/xerrors"
 )
 
 // Checker is a worker that executes SQLs and sends metrics.
+// The trackers and the delivery are shared by all the checkers, so they guard their states by themselves.
 type Checker struct {
-	ds        DataSource
+	dss       DataSources
 	notifiers Notifiers
+	alerts    *alertTracker
+	failures  *failureTracker
+	delivery  *eventDelivery
+	status    *statusTracker
+	internal  InternalMetricsConfig
 }
 
 // metric represents a measured value.
@@ -15,6 +27,10 @@ type metric struct {
 	name  string
 	value float64
 	tags  []string
+	// kind is one of the types of metrics. (default: MetricGauge)
+	kind string
+	// rate is a sample rate of the metric. (default: 1)
+	rate float64
 }
 
 // result represents monitoring results.
@@ -22,42 +38,269 @@ type result struct {
 	metrics []metric
 }
 
+// timeoutError represents that the query of the rule did not finish within the timeout.
+type timeoutError struct {
+	rule Rule
+	err  error
+}
+
+// Error implements the error interface.
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("rule timed out: %s (timeout = %s)", e.rule.Name, e.rule.Timeout)
+}
+
+// Unwrap returns the underlying error.
+func (e *timeoutError) Unwrap() error {
+	return e.err
+}
+
 // newChecker returns an instance of Checker.
-func newChecker(ds DataSource, notifiers Notifiers) *Checker {
+func newChecker(dss DataSources, notifiers Notifiers, alerts *alertTracker, failures *failureTracker, delivery *eventDelivery, status *statusTracker, internal InternalMetricsConfig) *Checker {
 	return &Checker{
-		ds:        ds,
+		dss:       dss,
 		notifiers: notifiers,
+		alerts:    alerts,
+		failures:  failures,
+		delivery:  delivery,
+		status:    status,
+		internal:  internal,
 	}
 }
 
-// run processes the monitoring task queue enqueued by the Scheduler.
-func (c *Checker) run(q <-chan Rule) {
+// run processes the monitoring task queue enqueued by the Scheduler until the ctx is done.
+// A task taken from the queue is always checked to the end.
+func (c *Checker) run(ctx context.Context, q <-chan task) {
 	log.Printf("checker: start")
 
 	for {
-		rule := <-q
-		log.Printf("checker: check: %s", rule.Name)
-
 		// dequeue the task and check.
-		if err := c.check(rule); err != nil {
-			log.Printf("checker: failed to check: %+v", err)
+		var t task
+		select {
+		case t = <-q:
+		case <-ctx.Done():
+			log.Printf("checker: stop")
+			return
+		}
+		c.process(context.Background(), t)
+		close(t.done)
+	}
+}
+
+// process checks the rule of the task and sends error events of the failures.
+// Only the first failure of a rule is sent as it is, and the following ones are suppressed
+// or summarized until the rule recovers, which is notified by a recovery event.
+// The internal metrics and the status of the check are recorded regardless of the result.
+func (c *Checker) process(ctx context.Context, t task) {
+	rule := t.rule
+	log.Printf("checker: check: %s", rule.Name)
+
+	start := time.Now()
+	stats, errs := c.check(ctx, rule)
+	stats.queueWait = start.Sub(t.enqueued)
+	c.status.checked(rule, start, time.Since(start), stats.rows, errors.Join(errs...))
+	c.instrument(ctx, rule, stats, errs)
+
+	if len(errs) == 0 {
+		if event := c.failures.succeeded(rule, time.Now()); event != nil {
+			log.Printf("checker: recovered: %s", rule.Name)
+			c.notify(ctx, rule, event)
+		}
+		return
+	}
+
+	for _, err := range errs {
+		log.Printf("checker: failed to check: %+v", err)
+	}
+
+	// The outage of the data source is notified by its health checker instead of each rule.
+	var de *dataSourceDownError
+	if len(errs) == 1 && xerrors.As(errs[0], &de) {
+		return
+	}
+
+	first, summary := c.failures.failed(rule, errs, time.Now())
+	if summary != nil {
+		c.notify(ctx, rule, summary, failedNotifiers(errs)...)
+	}
+	if !first {
+		if summary == nil {
+			log.Printf("checker: suppress error events: %s", rule.Name)
+		}
+		return
+	}
+
+	for _, err := range errs {
+		event := newErrorEvent(err)
+		var te *timeoutError
+		if xerrors.As(err, &te) {
+			event = newTimeoutEvent(te)
+		}
+		event.Tags = append(event.Tags, ruleTags(rule)...)
+
+		// The failed notifier would fail to receive the event, so it is reported to the others.
+		c.notify(ctx, rule, event, failedNotifiers([]error{err})...)
+	}
+}
+
+// failedNotifiers returns the errors of the notifiers which failed in the errors.
+func failedNotifiers(errs []error) []*notifierError {
+	failed := []*notifierError{}
+	for _, err := range errs {
+		var ne *notifierError
+		if xerrors.As(err, &ne) {
+			failed = append(failed, ne)
+		}
+	}
+	return failed
+}
+
+// notify sends the event of the rule to the notifiers of the rule except the failed ones.
+// If all the notifiers of the rule failed, the event is sent to the fallback chain of the delivery instead.
+func (c *Checker) notify(ctx context.Context, rule Rule, event *Event, failed ...*notifierError) {
+	event.Rule = rule.Name
+	event.Channel = rule.Channel
+
+	skipped := make(map[string]bool, len(failed))
+	for _, ne := range failed {
+		skipped[ne.notifier] = true
+	}
+
+	sent := 0
+	for _, name := range rule.notifierNames() {
+		if skipped[name] {
+			continue
+		}
+		c.delivery.send(ctx, name, event)
+		sent++
+	}
 
-			// send an error event to the notifier.
-			event := newErrorEvent(err)
-			if err := c.notifiers[rule.Notifier].Event(event); err != nil {
-				// Sending error event was failed.
-				// There is no way to notify errors, so we simply exit the program.
-				log.Fatalf("failed to send error event: %+v", err)
-			}
+	if sent == 0 && len(failed) > 0 {
+		c.delivery.sendFallback(ctx, failed[0].notifier, event, failed[0])
+	}
+}
+
+// instrument sends the internal metrics of the check through the notifiers of the rule.
+// Failures are only logged so as not to hide the result of the check.
+func (c *Checker) instrument(ctx context.Context, rule Rule, stats checkStats, errs []error) {
+	if c.internal.Disabled {
+		return
+	}
+
+	metrics := buildInternalMetrics(c.internal, rule, stats, errs)
+	if ps, ok := c.poolStatser(rule); ok {
+		metrics = append(metrics, buildPoolMetrics(c.internal, rule.dataSourceName(), ps.poolStats())...)
+	}
+	for _, name := range rule.notifierNames() {
+		mn, ok := c.notifiers[name].(metricsNotifier)
+		if !ok {
+			continue
+		}
+
+		if err := mn.putMetrics(ctx, metrics); err != nil {
+			log.Printf("checker: failed to send internal metrics: notifier = %s: %+v", name, err)
 		}
 	}
 }
 
-// check gets the metrics and sends them.
-func (c *Checker) check(rule Rule) error {
-	result, err := c.ds.Get(rule)
+// poolStatser returns the data source of the rule if it has a connection pool.
+func (c *Checker) poolStatser(rule Rule) (poolStatser, bool) {
+	ds := c.dss[rule.dataSourceName()]
+	// The health checker wraps the data source.
+	if h, ok := ds.(*healthChecker); ok {
+		ds = h.ds
+	}
+	ps, ok := ds.(poolStatser)
+	return ps, ok
+}
+
+// notifierError represents that a notifier of the rule failed.
+type notifierError struct {
+	notifier string
+	err      error
+}
+
+// Error implements the error interface.
+func (e *notifierError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *notifierError) Unwrap() error {
+	return e.err
+}
+
+// check gets the metrics and sends them to each notifier of the rule.
+// If the query fails, the only error is returned.
+// Otherwise a failure of a notifier does not stop delivery to the others,
+// and an error is returned for each failed notifier.
+// The returned errors are classified by newCheckError.
+func (c *Checker) check(ctx context.Context, rule Rule) (checkStats, []error) {
+	stats := checkStats{}
+
+	ds, ok := c.dss[rule.dataSourceName()]
+	if !ok {
+		return stats, []error{newCheckError(errorClassConfig, xerrors.Errorf("unknown data source: rule = %s, data_source = %s", rule.Name, rule.dataSourceName()))}
+	}
+
+	start := time.Now()
+	result, err := c.get(ctx, ds, rule)
+	stats.queryDuration = time.Since(start)
 	if err != nil {
-		return err
+		return stats, []error{newCheckError(errorClassQuery, err)}
+	}
+	stats.rows = len(result.Records)
+
+	metrics, err := buildMetricsForQueryResult(result, rule)
+	if err != nil {
+		return stats, []error{newCheckError(errorClassConvert, err)}
+	}
+
+	// The alert levels are evaluated once and the events are sent to each notifier.
+	// The events are sent even if the metrics failed to be put,
+	// because the transitions are not evaluated again.
+	events := c.alerts.evaluate(rule, metrics)
+
+	var errs []error
+	for _, name := range rule.notifierNames() {
+		notifier := c.notifiers[name]
+		if err := notifier.Put(ctx, result, rule); err != nil {
+			err = xerrors.Errorf("failed to put metrics: notifier = %s: %w", name, err)
+			errs = append(errs, newCheckError(errorClassNotifier, &notifierError{notifier: name, err: err}))
+		} else {
+			stats.metrics = len(metrics)
+		}
+
+		if err := c.alert(ctx, notifier, events); err != nil {
+			err = xerrors.Errorf("notifier = %s: %w", name, err)
+			errs = append(errs, newCheckError(errorClassAlert, &notifierError{notifier: name, err: err}))
+		}
+	}
+	return stats, errs
+}
+
+// alert sends the events of the alerts whose levels have changed.
+func (c *Checker) alert(ctx context.Context, notifier Notifier, events []*Event) error {
+	for _, event := range events {
+		log.Printf("checker: alert: %s", event.Title)
+		if err := notifier.Event(ctx, event); err != nil {
+			return xerrors.Errorf("failed to send alert event: %s: %w", event.Title, err)
+		}
+	}
+	return nil
+}
+
+// get queries the data source within the timeout of the rule.
+func (c *Checker) get(ctx context.Context, ds DataSource, rule Rule) (QueryResult, error) {
+	if rule.Timeout <= 0 {
+		return ds.Get(ctx, rule)
+	}
+
+	qctx, cancel := context.WithTimeout(ctx, rule.Timeout)
+	defer cancel()
+
+	result, err := ds.Get(qctx, rule)
+	if err != nil && xerrors.Is(qctx.Err(), context.DeadlineExceeded) {
+		return result, &timeoutError{rule: rule, err: err}
 	}
-	return c.notifiers[rule.Notifier].Put(result, rule)
+	return result, err
 }
@@ -0,0 +1,495 @@
+package cyqldog
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// mockDataSource is a mock of DataSource.
+type mockDataSource struct {
+	result QueryResult
+	err    error
+	delay  time.Duration
+}
+
+// Get implements an interface of DataSource for testing.
+// It waits for the delay or the context to be done, and returns the result.
+func (d *mockDataSource) Get(ctx context.Context, rule Rule) (QueryResult, error) {
+	select {
+	case <-time.After(d.delay):
+		return d.result, d.err
+	case <-ctx.Done():
+		return QueryResult{}, xerrors.Errorf("mock query cancelled: %w", ctx.Err())
+	}
+}
+
+// Close implements an interface of DataSource for testing.
+func (d *mockDataSource) Close() error {
+	return nil
+}
+
+// mockNotifier is a mock of Notifier.
+// It only records API calls.
+type mockNotifier struct {
+	mu      sync.Mutex
+	results []QueryResult
+	events  []Event
+	metrics []metric
+	// putErr is returned by Put instead of recording the result.
+	putErr error
+	// eventErr is returned by Event instead of recording the event.
+	eventErr error
+	// eventCalls is the number of calls of Event.
+	eventCalls int
+}
+
+// Put implements an interface of Notifier for testing.
+func (n *mockNotifier) Put(ctx context.Context, qr QueryResult, rule Rule) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.putErr != nil {
+		return n.putErr
+	}
+	n.results = append(n.results, qr)
+	return nil
+}
+
+// Event implements an interface of Notifier for testing.
+func (n *mockNotifier) Event(ctx context.Context, e *Event) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.eventCalls++
+	if n.eventErr != nil {
+		return n.eventErr
+	}
+	n.events = append(n.events, *e)
+	return nil
+}
+
+// putMetrics implements an interface of metricsNotifier for testing.
+func (n *mockNotifier) putMetrics(ctx context.Context, metrics []metric) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.metrics = append(n.metrics, metrics...)
+	return nil
+}
+
+func TestCheckerCheck(t *testing.T) {
+	cases := []struct {
+		name    string
+		ds      *mockDataSource
+		rule    Rule
+		ok      bool
+		timeout bool
+		class   string
+	}{
+		{
+			name: "ok",
+			ds: &mockDataSource{
+				result: QueryResult{Records: []Record{{"count": {String: "3"}}}},
+			},
+			rule: Rule{Name: "ok", Notifier: "mock", Timeout: time.Second},
+			ok:   true,
+		},
+		{
+			name: "no timeout",
+			ds: &mockDataSource{
+				result: QueryResult{Records: []Record{{"count": {String: "3"}}}},
+				delay:  10 * time.Millisecond,
+			},
+			rule: Rule{Name: "no timeout", Notifier: "mock"},
+			ok:   true,
+		},
+		{
+			name: "timeout",
+			ds: &mockDataSource{
+				delay: time.Second,
+			},
+			rule:    Rule{Name: "timeout", Notifier: "mock", Timeout: 10 * time.Millisecond},
+			ok:      false,
+			timeout: true,
+			class:   errorClassTimeout,
+		},
+		{
+			name: "error",
+			ds: &mockDataSource{
+				err: xerrors.New("query error"),
+			},
+			rule:    Rule{Name: "error", Notifier: "mock", Timeout: time.Second},
+			ok:      false,
+			timeout: false,
+			class:   errorClassQuery,
+		},
+		{
+			name: "convert error",
+			ds: &mockDataSource{
+				result: QueryResult{Records: []Record{{"count": {String: "three"}}}},
+			},
+			rule:    Rule{Name: "convert error", Notifier: "mock", ValueCols: []string{"count"}},
+			ok:      false,
+			timeout: false,
+			class:   errorClassConvert,
+		},
+		{
+			name:    "unknown data source",
+			ds:      &mockDataSource{},
+			rule:    Rule{Name: "unknown data source", DataSource: "unknown", Notifier: "mock"},
+			ok:      false,
+			timeout: false,
+			class:   errorClassConfig,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			n := &mockNotifier{}
+			c := newChecker(DataSources{"default": tc.ds}, Notifiers{"mock": n}, newAlertTracker(), newFailureTracker(ErrorEventsConfig{}), newEventDelivery(ErrorEventsConfig{}, Notifiers{"mock": n}, InternalMetricsConfig{}), newStatusTracker(), InternalMetricsConfig{})
+
+			_, errs := c.check(context.Background(), tc.rule)
+
+			if tc.ok {
+				if len(errs) > 0 {
+					t.Errorf("Checker.check(%+v) returns unexpected errs = %+v", tc.rule, errs)
+				}
+				if len(n.results) != 1 {
+					t.Errorf("Checker.check(%+v) puts %d results, want = 1", tc.rule, len(n.results))
+				}
+				return
+			}
+
+			if len(errs) != 1 {
+				t.Fatalf("expected Checker.check(%+v) returns an error, but errs = %+v", tc.rule, errs)
+			}
+			err := errs[0]
+
+			var te *timeoutError
+			if got := xerrors.As(err, &te); got != tc.timeout {
+				t.Errorf("Checker.check(%+v) returns err = %+v, want timeout = %v", tc.rule, err, tc.timeout)
+			}
+			if got := errorClass(err); got != tc.class {
+				t.Errorf("Checker.check(%+v) returns error class = %s, want = %s", tc.rule, got, tc.class)
+			}
+		})
+	}
+}
+
+func TestCheckerProcessNotifiers(t *testing.T) {
+	ds := &mockDataSource{
+		result: QueryResult{Records: []Record{{"count": {String: "3"}}}},
+	}
+	notifiers := Notifiers{
+		"ok1":    &mockNotifier{},
+		"failed": &mockNotifier{putErr: xerrors.New("connection refused")},
+		"ok2":    &mockNotifier{},
+	}
+	c := newChecker(DataSources{"default": ds}, notifiers, newAlertTracker(), newFailureTracker(ErrorEventsConfig{}), newEventDelivery(ErrorEventsConfig{}, notifiers, InternalMetricsConfig{}), newStatusTracker(), InternalMetricsConfig{})
+	rule := Rule{Name: "test", Notifiers: []string{"ok1", "failed", "ok2"}, ValueCols: []string{"count"}}
+
+	c.process(context.Background(), newTask(rule))
+
+	for _, name := range []string{"ok1", "ok2"} {
+		n := notifiers[name].(*mockNotifier)
+		if len(n.results) != 1 {
+			t.Errorf("Checker.process() puts %d results to %s, want = 1", len(n.results), name)
+		}
+		if len(n.events) != 1 {
+			t.Fatalf("Checker.process() sends %d events to %s, want = 1", len(n.events), name)
+		}
+		if e := n.events[0]; e.Level != "error" || !strings.Contains(e.Title, "notifier = failed") {
+			t.Errorf("Checker.process() sends event = %+v to %s, want the error of the failed notifier", e, name)
+		}
+
+		failures := []metric{}
+		for _, m := range n.metrics {
+			if m.name == "cyqldog.check.errors" {
+				failures = append(failures, m)
+			}
+		}
+		want := []string{"rule:test", "data_source:default", "error_class:notifier", "notifier:failed"}
+		if len(failures) != 1 || !reflect.DeepEqual(failures[0].tags, want) {
+			t.Errorf("Checker.process() sends errors = %+v to %s, want tags = %v", failures, name, want)
+		}
+	}
+
+	if n := notifiers["failed"].(*mockNotifier); len(n.events) != 0 {
+		t.Errorf("Checker.process() sends events = %+v to the failed notifier, want none", n.events)
+	}
+}
+
+func TestCheckerProcessSummaryEvents(t *testing.T) {
+	ds := &mockDataSource{
+		result: QueryResult{Records: []Record{{"count": {String: "3"}}}},
+	}
+	notifiers := Notifiers{
+		"ok":     &mockNotifier{},
+		"failed": &mockNotifier{putErr: xerrors.New("connection refused")},
+	}
+	config := ErrorEventsConfig{RepeatInterval: time.Nanosecond}
+	c := newChecker(DataSources{"default": ds}, notifiers, newAlertTracker(), newFailureTracker(config), newEventDelivery(config, notifiers, InternalMetricsConfig{Disabled: true}), newStatusTracker(), InternalMetricsConfig{Disabled: true})
+	rule := Rule{Name: "test", Notifiers: []string{"ok", "failed"}, ValueCols: []string{"count"}}
+
+	// The first error and the summary are sent only to the notifier which did not fail.
+	for i := 0; i < 2; i++ {
+		time.Sleep(time.Millisecond)
+		c.process(context.Background(), newTask(rule))
+	}
+
+	if n := notifiers["ok"].(*mockNotifier); len(n.events) != 2 || !strings.Contains(n.events[1].Title, "still failing") {
+		t.Errorf("Checker.process() sends events = %+v to ok, want the error and the summary", n.events)
+	}
+	if n := notifiers["failed"].(*mockNotifier); n.eventCalls != 0 {
+		t.Errorf("Checker.process() sends events = %+v to the failed notifier, want none", n.events)
+	}
+}
+
+func TestCheckerProcessAllNotifiersFailed(t *testing.T) {
+	cases := []struct {
+		name     string
+		fallback string
+		// fallbackEvents is the number of the events sent to the fallback notifier.
+		fallbackEvents int
+		deadLetter     bool
+	}{
+		{
+			name:           "fallback",
+			fallback:       "fallback",
+			fallbackEvents: 1,
+		},
+		{
+			name:       "dead letter",
+			deadLetter: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ds := &mockDataSource{
+				result: QueryResult{Records: []Record{{"count": {String: "3"}}}},
+			}
+			failed := &mockNotifier{putErr: xerrors.New("connection refused")}
+			fallback := &mockNotifier{}
+			notifiers := Notifiers{"failed": failed, "fallback": fallback}
+			path := filepath.Join(t.TempDir(), "dead_letter.jsonl")
+			config := ErrorEventsConfig{Fallback: tc.fallback, DeadLetter: path}
+			c := newChecker(DataSources{"default": ds}, notifiers, newAlertTracker(), newFailureTracker(config), newEventDelivery(config, notifiers, InternalMetricsConfig{Disabled: true}), newStatusTracker(), InternalMetricsConfig{Disabled: true})
+			rule := Rule{Name: "test", Notifier: "failed", ValueCols: []string{"count"}}
+
+			// The error of the only notifier of the rule is not sent to it, but through the fallback chain.
+			c.process(context.Background(), newTask(rule))
+
+			if failed.eventCalls != 0 {
+				t.Errorf("Checker.process() sends events = %+v to the failed notifier, want none", failed.events)
+			}
+			if len(fallback.events) != tc.fallbackEvents {
+				t.Errorf("Checker.process() sends %d events to the fallback notifier, want = %d", len(fallback.events), tc.fallbackEvents)
+			}
+			if got := c.delivery.failures(); got != 1 {
+				t.Errorf("eventDelivery.failures() = %d, want = 1", got)
+			}
+
+			buf, err := os.ReadFile(path)
+			if !tc.deadLetter {
+				if err == nil {
+					t.Errorf("Checker.process() writes the dead letter = %s, want none", buf)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to read the dead letter: %v", err)
+			}
+			if !strings.Contains(string(buf), `"notifier":"failed"`) || !strings.Contains(string(buf), "notifier = failed") {
+				t.Errorf("Checker.process() writes the dead letter = %s, want the error of the failed notifier", buf)
+			}
+		})
+	}
+}
+
+func TestCheckerProcessAlertsPutFailed(t *testing.T) {
+	ds := &mockDataSource{
+		result: QueryResult{Records: []Record{{"count": {String: "3"}}}},
+	}
+	n := &mockNotifier{putErr: xerrors.New("connection refused")}
+	c := newChecker(DataSources{"default": ds}, Notifiers{"mock": n}, newAlertTracker(), newFailureTracker(ErrorEventsConfig{}), newEventDelivery(ErrorEventsConfig{}, Notifiers{"mock": n}, InternalMetricsConfig{Disabled: true}), newStatusTracker(), InternalMetricsConfig{Disabled: true})
+	rule := Rule{
+		Name:      "test",
+		Notifier:  "mock",
+		ValueCols: []string{"count"},
+		Alerts:    []Alert{{ValueCol: "count", Critical: float64Ptr(1)}},
+	}
+
+	// The transition is evaluated only once, so it is sent even if the metrics are not.
+	c.process(context.Background(), newTask(rule))
+	if len(n.events) != 1 || n.events[0].Level != "error" || !strings.Contains(n.events[0].Title, "[critical]") {
+		t.Errorf("Checker.process() sends events = %+v, want a critical alert event", n.events)
+	}
+}
+
+func TestCheckerProcessErrorEvents(t *testing.T) {
+	ds := &mockDataSource{err: xerrors.New("connection refused")}
+	n := &mockNotifier{}
+	c := newChecker(DataSources{"default": ds}, Notifiers{"mock": n}, newAlertTracker(), newFailureTracker(ErrorEventsConfig{}), newEventDelivery(ErrorEventsConfig{}, Notifiers{"mock": n}, InternalMetricsConfig{Disabled: true}), newStatusTracker(), InternalMetricsConfig{Disabled: true})
+	rule := Rule{Name: "test", Notifier: "mock", ValueCols: []string{"count"}}
+
+	// Only the first failure is sent.
+	for i := 0; i < 3; i++ {
+		c.process(context.Background(), newTask(rule))
+	}
+	if len(n.events) != 1 {
+		t.Fatalf("Checker.process() sends %d events for 3 failures, want = 1", len(n.events))
+	}
+	want := []string{"cyqldog", "rule:test", "data_source:default"}
+	if e := n.events[0]; e.Level != "error" || !reflect.DeepEqual(e.Tags, want) {
+		t.Errorf("Checker.process() sends event = %+v, want an error event with tags = %v", e, want)
+	}
+
+	// The first success is sent as a recovery event.
+	ds.err = nil
+	ds.result = QueryResult{Records: []Record{{"count": {String: "3"}}}}
+	for i := 0; i < 2; i++ {
+		c.process(context.Background(), newTask(rule))
+	}
+	if len(n.events) != 2 {
+		t.Fatalf("Checker.process() sends %d events in total, want = 2", len(n.events))
+	}
+	if e := n.events[1]; e.Level != "success" || !reflect.DeepEqual(e.Tags, want) {
+		t.Errorf("Checker.process() sends event = %+v, want a success event with tags = %v", e, want)
+	}
+}
+
+func TestCheckerProcessInternalMetrics(t *testing.T) {
+	cases := []struct {
+		name     string
+		ds       *mockDataSource
+		internal InternalMetricsConfig
+		out      map[string]float64
+		tags     []string
+	}{
+		{
+			name: "ok",
+			ds: &mockDataSource{
+				result: QueryResult{Records: []Record{{"count": {String: "3"}}, {"count": {String: "4"}}}},
+			},
+			out: map[string]float64{
+				"cyqldog.check.rows":    2,
+				"cyqldog.check.metrics": 2,
+			},
+			tags: []string{"rule:test", "data_source:default"},
+		},
+		{
+			name: "error",
+			ds: &mockDataSource{
+				err: xerrors.New("query error"),
+			},
+			internal: InternalMetricsConfig{Namespace: "internal"},
+			out: map[string]float64{
+				"internal.check.rows":    0,
+				"internal.check.metrics": 0,
+				"internal.check.errors":  1,
+			},
+			tags: []string{"rule:test", "data_source:default", "error_class:query"},
+		},
+		{
+			name: "disabled",
+			ds: &mockDataSource{
+				result: QueryResult{Records: []Record{{"count": {String: "3"}}}},
+			},
+			internal: InternalMetricsConfig{Disabled: true},
+			out:      map[string]float64{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			n := &mockNotifier{}
+			c := newChecker(DataSources{"default": tc.ds}, Notifiers{"mock": n}, newAlertTracker(), newFailureTracker(ErrorEventsConfig{}), newEventDelivery(ErrorEventsConfig{}, Notifiers{"mock": n}, tc.internal), newStatusTracker(), tc.internal)
+			rule := Rule{Name: "test", Notifier: "mock", ValueCols: []string{"count"}}
+
+			c.process(context.Background(), newTask(rule))
+
+			got := make(map[string]metric)
+			for _, m := range n.metrics {
+				got[m.name] = m
+			}
+			if tc.internal.Disabled {
+				if len(got) != 0 {
+					t.Errorf("Checker.process() sends internal metrics = %+v, want none", n.metrics)
+				}
+				return
+			}
+
+			prefix := tc.internal.namespace() + ".check."
+			for _, name := range []string{"queue_wait", "query_duration"} {
+				if _, ok := got[prefix+name]; !ok {
+					t.Errorf("Checker.process() does not send %s%s", prefix, name)
+				}
+			}
+			for name, value := range tc.out {
+				m, ok := got[name]
+				if !ok {
+					t.Errorf("Checker.process() does not send %s", name)
+					continue
+				}
+				if m.value != value {
+					t.Errorf("Checker.process() sends %s = %v, want = %v", name, m.value, value)
+				}
+			}
+			if m, ok := got[prefix+"errors"]; ok {
+				if m.kind != MetricCount {
+					t.Errorf("Checker.process() sends %s as %s, want = %s", m.name, m.kind, MetricCount)
+				}
+				if !reflect.DeepEqual(m.tags, tc.tags) {
+					t.Errorf("Checker.process() sends %s with tags = %v, want = %v", m.name, m.tags, tc.tags)
+				}
+			} else if m := got[prefix+"rows"]; !reflect.DeepEqual(m.tags, tc.tags) {
+				t.Errorf("Checker.process() sends %s with tags = %v, want = %v", m.name, m.tags, tc.tags)
+			}
+		})
+	}
+}
+
+func TestNewTimeoutEvent(t *testing.T) {
+	rule := Rule{Name: "slow", Timeout: 5 * time.Second}
+	e := newTimeoutEvent(&timeoutError{rule: rule, err: context.DeadlineExceeded})
+
+	if e.Level != "error" {
+		t.Errorf("newTimeoutEvent() returns level = %s, want = error", e.Level)
+	}
+	if !strings.Contains(e.Title, "slow") {
+		t.Errorf("newTimeoutEvent() returns title = %s, want the rule name", e.Title)
+	}
+}
+
+func TestCheckerProcessQueueWait(t *testing.T) {
+	ds := &mockDataSource{
+		result: QueryResult{Records: []Record{{"count": {String: "3"}}}},
+		delay:  200 * time.Millisecond,
+	}
+	n := &mockNotifier{}
+	notifiers := Notifiers{"mock": n}
+	c := newChecker(DataSources{"default": ds}, notifiers, newAlertTracker(), newFailureTracker(ErrorEventsConfig{}), newEventDelivery(ErrorEventsConfig{}, notifiers, InternalMetricsConfig{}), newStatusTracker(), InternalMetricsConfig{})
+	rule := Rule{Name: "test", Notifier: "mock", ValueCols: []string{"count"}}
+
+	// The task waits in the queue for 50ms before the slow query.
+	task := newTask(rule)
+	task.enqueued = task.enqueued.Add(-50 * time.Millisecond)
+	c.process(context.Background(), task)
+
+	got := make(map[string]float64)
+	for _, m := range n.metrics {
+		got[m.name] = m.value
+	}
+	if wait := got["cyqldog.check.queue_wait"]; wait < 0.05 || wait >= 0.2 {
+		t.Errorf("Checker.process() sends queue_wait = %vs, want the wait in the queue excluding the query", wait)
+	}
+	if d := got["cyqldog.check.query_duration"]; d < 0.2 {
+		t.Errorf("Checker.process() sends query_duration = %vs, want >= 0.2s", d)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"html/template"
 	"os"
 	"strings"
+	"time"
 
 	"golang.org/x/xerrors"
 
@@ -14,9 +15,21 @@ import (
 // Config represents the structure of the configuration file.
 type Config struct {
 	// DB is a configuration of the database to connect.
+	// This is used as the data source named "default".
 	DB DataSourceConfig `yaml:"data_source"`
+	// DataSources are configurations of the named databases to connect.
+	DataSources DataSourcesConfig `yaml:"data_sources"`
 	// Notifiers are configurations of output plugins.
 	Notifiers NotifiersConfig `yaml:"notifiers"`
+	// InternalMetrics is a configuration of the metrics about cyqldog itself.
+	InternalMetrics InternalMetricsConfig `yaml:"internal_metrics"`
+	// ErrorEvents is a configuration of the error events of failed checks.
+	ErrorEvents ErrorEventsConfig `yaml:"error_events"`
+	// Admin is a configuration of the admin server.
+	Admin AdminConfig `yaml:"admin"`
+	// Timeout is a default timeout of the query for rules without timeout.
+	// If zero, queries never time out.
+	Timeout time.Duration `yaml:"timeout"`
 	// Rules are a list of rules to monitor
 	Rules []Rule `yaml:"rules"`
 }
@@ -42,9 +55,82 @@ func newConfig(filename string) (*Config, error) {
 		return nil, xerrors.Errorf("failed to parse yaml: %s: %w", filename, err)
 	}
 
+	c.applyDefaults()
+
+	// Check the configuration before use.
+	if err := c.Validate(); err != nil {
+		return nil, xerrors.Errorf("invalid config: %s: %w", filename, err)
+	}
+
+	return &c, nil
+}
+
+// applyDefaults applies the default timeout to rules.
+func (c *Config) applyDefaults() {
+	for i := range c.Rules {
+		if c.Rules[i].Timeout == 0 {
+			c.Rules[i].Timeout = c.Timeout
+		}
+	}
+}
+
+// newComponentsConfig returns a Config to run the rules of the config with the prebuilt components.
+// The rules are validated against the names of the components.
+func newComponentsConfig(config Config, dss DataSources, notifiers Notifiers) (*Config, error) {
+	c := config
+	c.DB = DataSourceConfig{}
+	c.DataSources = make(DataSourcesConfig, len(dss))
+	dataSources := make(map[string]bool, len(dss))
+	for name := range dss {
+		c.DataSources[name] = DataSourceConfig{MaxConcurrency: config.DataSources[name].MaxConcurrency}
+		dataSources[name] = true
+	}
+
+	c.Notifiers = make(NotifiersConfig, len(notifiers))
+	types := make(map[string]string, len(notifiers))
+	for name, n := range notifiers {
+		c.Notifiers[name] = NotifierConfig{Type: notifierType(n)}
+		types[name] = notifierType(n)
+	}
+
+	// Copy the rules not to modify the given config.
+	c.Rules = append([]Rule{}, config.Rules...)
+	c.applyDefaults()
+
+	errs := ValidationErrors{}
+	c.validateRules(dataSources, types, &errs)
+	if len(errs) > 0 {
+		return nil, xerrors.Errorf("invalid config: %w", errs)
+	}
+
 	return &c, nil
 }
 
+// ValidateConfig loads the configuration file and validates it.
+// If the configuration is invalid, the returned error wraps ValidationErrors.
+func ValidateConfig(filename string) error {
+	_, err := newConfig(filename)
+	return err
+}
+
+// dataSourcesConfig returns configurations of all data sources including the default one.
+func (c *Config) dataSourcesConfig() (DataSourcesConfig, error) {
+	dss := make(DataSourcesConfig, len(c.DataSources)+1)
+	for name, ds := range c.DataSources {
+		dss[name] = ds
+	}
+
+	// For backward compatibility, the single data_source is used as the default.
+	if len(c.DB.Driver) > 0 {
+		if _, ok := dss[defaultDataSourceName]; ok {
+			return nil, xerrors.Errorf("data source %s is defined in both data_source and data_sources", defaultDataSourceName)
+		}
+		dss[defaultDataSourceName] = c.DB
+	}
+
+	return dss, nil
+}
+
 // envMap is a map of environment variables.
 type envMap map[string]string
 
@@ -2,7 +2,11 @@ package cyqldog
 
 import (
 	"os"
+	"reflect"
+	"sort"
 	"testing"
+
+	yaml "gopkg.in/yaml.v2"
 )
 
 func TestNewConfig(t *testing.T) {
@@ -18,6 +22,10 @@ func TestNewConfig(t *testing.T) {
 			in: "test-fixtures/mysql/cyqldog.yml",
 			ok: true,
 		},
+		{
+			in: "test-fixtures/postgres/cyqldog_multi.yml",
+			ok: true,
+		},
 		{
 			in: "test-fixtures/no_such_file.yml",
 			ok: false,
@@ -30,6 +38,10 @@ func TestNewConfig(t *testing.T) {
 			in: "test-fixtures/postgres/cyqldog_ng2.yml",
 			ok: false,
 		},
+		{
+			in: "test-fixtures/postgres/cyqldog_ng3.yml",
+			ok: false,
+		},
 	}
 
 	for _, tc := range cases {
@@ -45,6 +57,116 @@ func TestNewConfig(t *testing.T) {
 	}
 }
 
+func TestConfigDataSourcesConfig(t *testing.T) {
+	cases := []struct {
+		config Config
+		names  []string
+		ok     bool
+	}{
+		{
+			config: Config{
+				DB: DataSourceConfig{Driver: "postgres"},
+			},
+			names: []string{"default"},
+			ok:    true,
+		},
+		{
+			config: Config{
+				DataSources: DataSourcesConfig{
+					"primary": DataSourceConfig{Driver: "postgres"},
+					"replica": DataSourceConfig{Driver: "mysql"},
+				},
+			},
+			names: []string{"primary", "replica"},
+			ok:    true,
+		},
+		{
+			config: Config{
+				DB: DataSourceConfig{Driver: "postgres"},
+				DataSources: DataSourcesConfig{
+					"replica": DataSourceConfig{Driver: "mysql"},
+				},
+			},
+			names: []string{"default", "replica"},
+			ok:    true,
+		},
+		{
+			config: Config{
+				DB: DataSourceConfig{Driver: "postgres"},
+				DataSources: DataSourcesConfig{
+					"default": DataSourceConfig{Driver: "mysql"},
+				},
+			},
+			ok: false,
+		},
+	}
+
+	for _, tc := range cases {
+		got, err := tc.config.dataSourcesConfig()
+
+		if !tc.ok {
+			if err == nil {
+				t.Errorf("expected dataSourcesConfig() with config = %+v returns error, but err == nil", tc.config)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("dataSourcesConfig() with config = %+v returns unexpected error: %+v", tc.config, err)
+			continue
+		}
+
+		names := []string{}
+		for name := range got {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		if !reflect.DeepEqual(names, tc.names) {
+			t.Errorf("dataSourcesConfig() with config = %+v returns names = %v, want = %v", tc.config, names, tc.names)
+		}
+	}
+}
+
+func TestNotifiersConfigUnmarshalYAML(t *testing.T) {
+	in := `
+dogstatsd:
+  host: 127.0.0.1
+  port: 8125
+analytics:
+  type: dogstatsd
+  host: analytics.example.com
+  port: 8125
+  namespace: analytics
+  tags: ["team:data"]
+metrics:
+  type: prometheus
+  listen_address: ":9187"
+`
+	want := NotifiersConfig{
+		"dogstatsd": {
+			Type:      NotifierDogstatsd,
+			Dogstatsd: DogstatsdConfig{Host: "127.0.0.1", Port: "8125"},
+		},
+		"analytics": {
+			Type:      NotifierDogstatsd,
+			Dogstatsd: DogstatsdConfig{Host: "analytics.example.com", Port: "8125", Namespace: "analytics", Tags: []string{"team:data"}},
+		},
+		"metrics": {
+			Type:       NotifierPrometheus,
+			Prometheus: PrometheusConfig{ListenAddress: ":9187"},
+		},
+	}
+
+	got := NotifiersConfig{}
+	if err := yaml.Unmarshal([]byte(in), &got); err != nil {
+		t.Fatalf("yaml.Unmarshal() returns unexpected err = %+v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("yaml.Unmarshal()\n got = %+v,\nwant = %+v", got, want)
+	}
+}
+
 func TestRenderEnv(t *testing.T) {
 	cases := []struct {
 		in  []byte
@@ -0,0 +1,289 @@
+package cyqldog
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// cronSchedule represents a schedule in the cron syntax.
+// Each field is a bit set of the values to match.
+type cronSchedule struct {
+	second, minute, hour, dom, month, dow uint64
+	location                              *time.Location
+}
+
+// cronBounds represents the range of values of a field.
+type cronBounds struct {
+	min, max uint
+	names    map[string]uint
+}
+
+var (
+	cronSeconds = cronBounds{min: 0, max: 59}
+	cronMinutes = cronBounds{min: 0, max: 59}
+	cronHours   = cronBounds{min: 0, max: 23}
+	cronDom     = cronBounds{min: 1, max: 31}
+	cronMonths  = cronBounds{min: 1, max: 12, names: map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Both 0 and 7 are Sunday.
+	cronDow = cronBounds{min: 0, max: 7, names: map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// cronMacros are the predefined schedules in the 6 fields format.
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+// cronStarBit is set when the field is unrestricted with "*" or "?".
+// It is used to decide how the day of month and the day of week are combined.
+const cronStarBit = 1 << 63
+
+// cronSearchYears is a limit to search the next time,
+// so that a schedule which never matches (e.g. Feb 30) does not loop forever.
+const cronSearchYears = 5
+
+// parseCronSchedule parses a schedule in the standard cron syntax.
+// The spec has 5 fields (minute hour dom month dow),
+// or 6 fields with a leading second field.
+// The schedule is evaluated in the given location.
+func parseCronSchedule(spec string, location *time.Location) (*cronSchedule, error) {
+	if macro, ok := cronMacros[strings.TrimSpace(spec)]; ok {
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		// Run at the beginning of the minute.
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, xerrors.Errorf("invalid cron schedule: %q: expected 5 or 6 fields, but got %d", spec, len(fields))
+	}
+
+	bounds := []cronBounds{cronSeconds, cronMinutes, cronHours, cronDom, cronMonths, cronDow}
+	bits := make([]uint64, len(fields))
+	for i, f := range fields {
+		b, err := parseCronField(f, bounds[i])
+		if err != nil {
+			return nil, xerrors.Errorf("invalid cron schedule: %q: %w", spec, err)
+		}
+		bits[i] = b
+	}
+
+	// Sunday is either 0 or 7.
+	if bits[5]&(1<<7) > 0 {
+		bits[5] = bits[5]&^(1<<7) | 1<<0
+	}
+
+	if location == nil {
+		location = time.Local
+	}
+
+	return &cronSchedule{
+		second:   bits[0],
+		minute:   bits[1],
+		hour:     bits[2],
+		dom:      bits[3],
+		month:    bits[4],
+		dow:      bits[5],
+		location: location,
+	}, nil
+}
+
+// parseCronField parses a comma separated list of ranges into a bit set.
+func parseCronField(field string, b cronBounds) (uint64, error) {
+	var bits uint64
+	for _, expr := range strings.Split(field, ",") {
+		r, err := parseCronRange(expr, b)
+		if err != nil {
+			return 0, err
+		}
+		bits |= r
+	}
+	return bits, nil
+}
+
+// parseCronRange parses a range such as "*", "5", "1-5", "*/10" or "10-30/5" into a bit set.
+func parseCronRange(expr string, b cronBounds) (uint64, error) {
+	rangeAndStep := strings.Split(expr, "/")
+	lowAndHigh := strings.Split(rangeAndStep[0], "-")
+
+	var start, end, step uint
+	var extra uint64
+	var err error
+
+	switch {
+	case lowAndHigh[0] == "*" || lowAndHigh[0] == "?":
+		if len(lowAndHigh) > 1 {
+			return 0, xerrors.Errorf("invalid range: %s", expr)
+		}
+		start, end = b.min, b.max
+		extra = cronStarBit
+	default:
+		if start, err = parseCronValue(lowAndHigh[0], b); err != nil {
+			return 0, err
+		}
+		switch len(lowAndHigh) {
+		case 1:
+			end = start
+		case 2:
+			if end, err = parseCronValue(lowAndHigh[1], b); err != nil {
+				return 0, err
+			}
+		default:
+			return 0, xerrors.Errorf("invalid range: %s", expr)
+		}
+	}
+
+	switch len(rangeAndStep) {
+	case 1:
+		step = 1
+	case 2:
+		if step, err = parseCronValue(rangeAndStep[1], cronBounds{min: 1, max: b.max}); err != nil {
+			return 0, err
+		}
+		// "N/step" means from N to the max.
+		if len(lowAndHigh) == 1 && extra == 0 {
+			end = b.max
+		}
+	default:
+		return 0, xerrors.Errorf("invalid step: %s", expr)
+	}
+
+	if start > end {
+		return 0, xerrors.Errorf("invalid range: %s: beginning of range is after end", expr)
+	}
+
+	var bits uint64
+	for i := start; i <= end; i += step {
+		bits |= 1 << i
+	}
+	return bits | extra, nil
+}
+
+// parseCronValue parses a number or a name within the bounds.
+func parseCronValue(s string, b cronBounds) (uint, error) {
+	if v, ok := b.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, xerrors.Errorf("invalid value: %s: %w", s, err)
+	}
+	if uint(v) < b.min || uint(v) > b.max {
+		return 0, xerrors.Errorf("value out of range (%d-%d): %s", b.min, b.max, s)
+	}
+	return uint(v), nil
+}
+
+// next returns the next time after t which matches the schedule.
+// It returns the zero time if no time matches within cronSearchYears.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	orig := t.Location()
+	loc := s.location
+	t = t.In(loc)
+
+	// Start at the next whole second.
+	t = t.Add(time.Second - time.Duration(t.Nanosecond()))
+
+	// added is set once a field is incremented, so that lower fields are reset only once.
+	added := false
+	yearLimit := t.Year() + cronSearchYears
+
+wrap:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for 1<<uint(t.Month())&s.month == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+		}
+		t = t.AddDate(0, 1, 0)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+
+	for !s.dayMatches(t) {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		}
+		t = t.AddDate(0, 0, 1)
+
+		// The midnight may be skipped or repeated by the daylight saving time.
+		if t.Hour() != 0 {
+			if t.Hour() > 12 {
+				t = t.Add(time.Duration(24-t.Hour()) * time.Hour)
+			} else {
+				t = t.Add(time.Duration(-t.Hour()) * time.Hour)
+			}
+		}
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+
+	for 1<<uint(t.Hour())&s.hour == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+		}
+		t = t.Add(time.Hour)
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+
+	for 1<<uint(t.Minute())&s.minute == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Minute)
+		}
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+
+	for 1<<uint(t.Second())&s.second == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Second)
+		}
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto wrap
+		}
+	}
+
+	return t.In(orig)
+}
+
+// dayMatches returns true if the day of month and the day of week match the schedule.
+// As in the standard cron, if both fields are restricted, either of them has to match.
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := 1<<uint(t.Day())&s.dom > 0
+	dowMatch := 1<<uint(t.Weekday())&s.dow > 0
+	if s.dom&cronStarBit > 0 || s.dow&cronStarBit > 0 {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
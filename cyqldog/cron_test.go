@@ -0,0 +1,84 @@
+package cyqldog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("failed to load location: %v", err)
+	}
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("failed to load location: %v", err)
+	}
+
+	cases := []struct {
+		spec string
+		loc  *time.Location
+		from string
+		out  string
+	}{
+		// 5 fields.
+		{spec: "* * * * *", loc: time.UTC, from: "2024-01-01T00:00:30Z", out: "2024-01-01T00:01:00Z"},
+		{spec: "*/15 * * * *", loc: time.UTC, from: "2024-01-01T00:16:00Z", out: "2024-01-01T00:30:00Z"},
+		{spec: "0 0 * * *", loc: time.UTC, from: "2024-01-01T00:00:00Z", out: "2024-01-02T00:00:00Z"},
+		{spec: "30 9 * * mon-fri", loc: time.UTC, from: "2024-01-05T10:00:00Z", out: "2024-01-08T09:30:00Z"},
+		{spec: "0 0 1 jan *", loc: time.UTC, from: "2024-06-01T00:00:00Z", out: "2025-01-01T00:00:00Z"},
+		{spec: "0 12 29 2 *", loc: time.UTC, from: "2024-03-01T00:00:00Z", out: "2028-02-29T12:00:00Z"},
+		{spec: "0 0 * * 7", loc: time.UTC, from: "2024-01-01T00:00:00Z", out: "2024-01-07T00:00:00Z"},
+		{spec: "0 8-18/5 * * *", loc: time.UTC, from: "2024-01-01T09:00:00Z", out: "2024-01-01T13:00:00Z"},
+		// Either the day of month or the day of week matches.
+		{spec: "0 0 15 * fri", loc: time.UTC, from: "2024-01-01T00:00:00Z", out: "2024-01-05T00:00:00Z"},
+		// 6 fields with seconds.
+		{spec: "*/10 * * * * *", loc: time.UTC, from: "2024-01-01T00:00:01Z", out: "2024-01-01T00:00:10Z"},
+		// Macros.
+		{spec: "@hourly", loc: time.UTC, from: "2024-01-01T00:59:59Z", out: "2024-01-01T01:00:00Z"},
+		{spec: "@monthly", loc: time.UTC, from: "2024-01-15T00:00:00Z", out: "2024-02-01T00:00:00Z"},
+		// Timezones.
+		{spec: "0 0 * * *", loc: tokyo, from: "2024-01-01T00:00:00Z", out: "2024-01-01T15:00:00Z"},
+		// Midnight in New York is 05:00 UTC in winter and 04:00 UTC in summer.
+		{spec: "0 0 * * *", loc: ny, from: "2024-03-10T06:00:00Z", out: "2024-03-11T04:00:00Z"},
+		// Never matches.
+		{spec: "0 0 30 2 *", loc: time.UTC, from: "2024-01-01T00:00:00Z", out: "0001-01-01T00:00:00Z"},
+	}
+
+	for _, tc := range cases {
+		s, err := parseCronSchedule(tc.spec, tc.loc)
+		if err != nil {
+			t.Errorf("parseCronSchedule(%s) returns unexpected err = %+v", tc.spec, err)
+			continue
+		}
+
+		from, _ := time.Parse(time.RFC3339, tc.from)
+		got := s.next(from).UTC().Format(time.RFC3339)
+		if got != tc.out {
+			t.Errorf("parseCronSchedule(%s, %s).next(%s) = %s, want = %s", tc.spec, tc.loc, tc.from, got, tc.out)
+		}
+	}
+}
+
+func TestParseCronScheduleError(t *testing.T) {
+	cases := []string{
+		"",
+		"* * * *",
+		"* * * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"@every 5m",
+	}
+
+	for _, spec := range cases {
+		if _, err := parseCronSchedule(spec, time.UTC); err == nil {
+			t.Errorf("expected parseCronSchedule(%q) returns error, but err == nil", spec)
+		}
+	}
+}
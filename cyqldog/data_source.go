@@ -1,7 +1,10 @@
 package cyqldog
 
 import (
+	"context"
+	"log"
 	"strings"
+	"time"
 
 	"golang.org/x/xerrors"
 
@@ -10,12 +13,29 @@ import (
 
 // DataSource is an interface which get metrics from.
 type DataSource interface {
-	Get(rule Rule) (QueryResult, error)
+	Get(ctx context.Context, rule Rule) (QueryResult, error)
 	Close() error
 }
 
+// DataSources is a map of data sources.
+type DataSources map[string]DataSource
+
+// defaultDataSourceName is a name of the data source used by rules without data_source.
+const defaultDataSourceName = "default"
+
 // Record is a map of column / value pairs representing one row.
-type Record map[string]string
+type Record map[string]Value
+
+// Value is a value of a column.
+// The value is stored as a string to generalize how to handle data at notifications.
+type Value struct {
+	// String is the value converted to a string. It is empty if Null is true.
+	String string
+	// Null is true if the value is NULL.
+	Null bool
+	// Time is the original value if the column is a timestamp.
+	Time time.Time
+}
 
 // QueryResult has multiple records.
 type QueryResult struct {
@@ -33,11 +53,128 @@ type DataSourceConfig struct {
 	// These options are passed to sql.Open.
 	// The supported options are depend on the database driver.
 	Options DataSourceOptions `yaml:"options"`
+	// MaxConcurrency is the maximum number of queries running at the same time. (default: 1)
+	MaxConcurrency int `yaml:"max_concurrency"`
+	// ReadOnly runs each query in a read-only transaction, which is rolled back afterwards.
+	// The queries of the rules are also checked not to contain multiple statements, DML or DDL.
+	ReadOnly bool `yaml:"read_only"`
+	// MaxOpenConns is the maximum number of open connections. (default: unlimited)
+	MaxOpenConns int `yaml:"max_open_conns"`
+	// MaxIdleConns is the maximum number of idle connections. (default: 2)
+	MaxIdleConns int `yaml:"max_idle_conns"`
+	// ConnMaxLifetime is the maximum time a connection may be reused. (default: unlimited)
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	// ConnMaxIdleTime is the maximum time a connection may be idle. (default: unlimited)
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+	// ConnectRetries is the number of retries to connect to the database. (default: 0)
+	// If negative, it retries until connected.
+	ConnectRetries int `yaml:"connect_retries"`
+	// ConnectBackoff is a wait before the first retry, which doubles on each retry. (default: 1s)
+	ConnectBackoff time.Duration `yaml:"connect_backoff"`
+	// ConnectMaxBackoff is the maximum wait between retries. (default: 1m)
+	ConnectMaxBackoff time.Duration `yaml:"connect_max_backoff"`
+	// HealthCheckInterval is an interval to ping the database in the background.
+	// If zero, the database is not checked.
+	HealthCheckInterval time.Duration `yaml:"health_check_interval"`
 }
 
+// DataSourcesConfig is a map of the named configurations of databases to connect.
+type DataSourcesConfig map[string]DataSourceConfig
+
 // DataSourceOptions is a map of options to connect.
 type DataSourceOptions map[string]string
 
+// newDataSources returns an instance of DataSources.
+// If one of the connections fails, the already opened data sources are closed.
+// Retrying to connect stops when the ctx is done.
+func newDataSources(ctx context.Context, c DataSourcesConfig) (DataSources, error) {
+	dss := make(DataSources, len(c))
+
+	for name, dsc := range c {
+		ds, err := connectDataSource(ctx, name, dsc)
+		if err != nil {
+			dss.Close()
+			return nil, xerrors.Errorf("failed to initialize data source: %s: %w", name, err)
+		}
+		dss[name] = ds
+	}
+
+	return dss, nil
+}
+
+// Default values of the connection retries.
+const (
+	defaultConnectBackoff    = time.Second
+	defaultConnectMaxBackoff = time.Minute
+)
+
+// connectBackoff returns the backoff with the default applied.
+func (s *DataSourceConfig) connectBackoff() time.Duration {
+	if s.ConnectBackoff == 0 {
+		return defaultConnectBackoff
+	}
+	return s.ConnectBackoff
+}
+
+// connectMaxBackoff returns the maximum backoff with the default applied.
+func (s *DataSourceConfig) connectMaxBackoff() time.Duration {
+	if s.ConnectMaxBackoff == 0 {
+		return defaultConnectMaxBackoff
+	}
+	return s.ConnectMaxBackoff
+}
+
+// connectDataSource connects to the data source,
+// retrying with exponential backoff so that a database briefly down does not prevent starting.
+// It gives up retrying when the ctx is done.
+func connectDataSource(ctx context.Context, name string, c DataSourceConfig) (DataSource, error) {
+	backoff := c.connectBackoff()
+	for i := 0; ; i++ {
+		ds, err := newDB(ctx, c)
+		if err == nil {
+			return ds, nil
+		}
+		if c.ConnectRetries >= 0 && i >= c.ConnectRetries {
+			return nil, err
+		}
+
+		log.Printf("data source: retry to connect in %s: %s: %+v", backoff, name, err)
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, xerrors.Errorf("gave up retrying to connect: %v: %w", err, ctx.Err())
+		}
+		backoff *= 2
+		if max := c.connectMaxBackoff(); backoff > max {
+			backoff = max
+		}
+	}
+}
+
+// Close closes all the data sources.
+func (dss DataSources) Close() error {
+	var firstErr error
+	for name, ds := range dss {
+		if err := ds.Close(); err != nil && firstErr == nil {
+			firstErr = xerrors.Errorf("failed to close data source: %s: %w", name, err)
+		}
+	}
+	return firstErr
+}
+
+// maxConcurrency returns the maximum number of queries running at the same time.
+func (s *DataSourceConfig) maxConcurrency() int {
+	if s.MaxConcurrency < 1 {
+		return 1
+	}
+	return s.MaxConcurrency
+}
+
+// supportedDrivers are the names of the supported database drivers.
+var supportedDrivers = []string{"postgres", "mysql"}
+
 // getDataSourceName returns a data source name to use for sql.Open.
 func (s *DataSourceConfig) getDataSourceName() (string, error) {
 	// Check database driver
@@ -77,15 +214,18 @@ func (s *DataSourceConfig) getDataSourceNameMySQL() (string, error) {
 	c.Addr = o["host"] + ":" + port
 	c.DBName = o["dbname"]
 
-	// delete basic options.
-	delete(o, "user")
-	delete(o, "password")
-	delete(o, "host")
-	delete(o, "port")
-	delete(o, "dbname")
-
-	// set other connection params.
-	c.Params = o
+	// set other connection params except basic options.
+	// Copy them not to modify the configuration,
+	// which is compared with a new one when reloading.
+	params := make(map[string]string, len(o))
+	for k, v := range o {
+		switch k {
+		case "user", "password", "host", "port", "dbname":
+		default:
+			params[k] = v
+		}
+	}
+	c.Params = params
 
 	// render DSN format
 	return c.FormatDSN(), nil
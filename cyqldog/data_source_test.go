@@ -1,10 +1,15 @@
 package cyqldog
 
 import (
+	"context"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
 )
 
 func TestGetDataSourceName(t *testing.T) {
@@ -155,6 +160,25 @@ func TestGetDataSourceNameMySQL(t *testing.T) {
 
 }
 
+func TestDataSourceConfigMaxConcurrency(t *testing.T) {
+	cases := []struct {
+		in  int
+		out int
+	}{
+		{in: 0, out: 1},
+		{in: -1, out: 1},
+		{in: 1, out: 1},
+		{in: 4, out: 4},
+	}
+
+	for _, tc := range cases {
+		s := DataSourceConfig{MaxConcurrency: tc.in}
+		if got := s.maxConcurrency(); got != tc.out {
+			t.Errorf("maxConcurrency() with max_concurrency = %d returns %d, want = %d", tc.in, got, tc.out)
+		}
+	}
+}
+
 // splittedStringEqual compare whether or not strings splitted by separater are
 // the same regardless of the order.
 func splittedStringEqual(s1 string, s2 string, sep string) bool {
@@ -164,3 +188,100 @@ func splittedStringEqual(s1 string, s2 string, sep string) bool {
 	sort.Strings(ss2)
 	return reflect.DeepEqual(ss1, ss2)
 }
+
+// flakyAttempts is the number of connections to the test_flaky driver.
+var flakyAttempts int
+
+func init() {
+	// The test_flaky driver fails to connect as many times as the failures option.
+	RegisterDataSource("test_flaky", func(c DataSourceConfig) (DataSource, error) {
+		flakyAttempts++
+		failures, _ := strconv.Atoi(c.Options["failures"])
+		if flakyAttempts <= failures {
+			return nil, xerrors.New("connection refused")
+		}
+		return &mockDataSource{}, nil
+	})
+}
+
+func TestConnectDataSource(t *testing.T) {
+	cases := []struct {
+		name     string
+		failures string
+		retries  int
+		ok       bool
+		attempts int
+	}{
+		{
+			name:     "no retry",
+			failures: "1",
+			retries:  0,
+			ok:       false,
+			attempts: 1,
+		},
+		{
+			name:     "retry",
+			failures: "2",
+			retries:  3,
+			ok:       true,
+			attempts: 3,
+		},
+		{
+			name:     "too many failures",
+			failures: "5",
+			retries:  3,
+			ok:       false,
+			attempts: 4,
+		},
+		{
+			name:     "retry forever",
+			failures: "5",
+			retries:  -1,
+			ok:       true,
+			attempts: 6,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			flakyAttempts = 0
+			c := DataSourceConfig{
+				Driver:            "test_flaky",
+				Options:           DataSourceOptions{"failures": tc.failures},
+				ConnectRetries:    tc.retries,
+				ConnectBackoff:    time.Millisecond,
+				ConnectMaxBackoff: 2 * time.Millisecond,
+			}
+
+			_, err := connectDataSource(context.Background(), "default", c)
+			if ok := err == nil; ok != tc.ok {
+				t.Errorf("connectDataSource() returns err = %+v, want ok = %v", err, tc.ok)
+			}
+			if flakyAttempts != tc.attempts {
+				t.Errorf("connectDataSource() tries %d times, want = %d", flakyAttempts, tc.attempts)
+			}
+		})
+	}
+}
+
+func TestConnectDataSourceCancel(t *testing.T) {
+	flakyAttempts = 0
+	c := DataSourceConfig{
+		Driver:         "test_flaky",
+		Options:        DataSourceOptions{"failures": "1000000"},
+		ConnectRetries: -1,
+		ConnectBackoff: time.Hour,
+	}
+
+	// Retrying forever stops when the ctx is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := connectDataSource(ctx, "default", c)
+	if !xerrors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("connectDataSource() with done ctx returns err = %+v, want deadline exceeded", err)
+	}
+	if flakyAttempts != 1 {
+		t.Errorf("connectDataSource() tries %d times, want = 1", flakyAttempts)
+	}
+}
@@ -1,21 +1,36 @@
 package cyqldog
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"golang.org/x/xerrors"
 )
 
 // DB is an implementation of DataSource.
 type DB struct {
-	db *sql.DB
+	db     *sql.DB
+	driver string
+	// readOnly runs queries in read-only transactions.
+	readOnly bool
 }
 
+// killQueryTimeout is a timeout to cancel a running query on the MySQL server.
+const killQueryTimeout = 10 * time.Second
+
 // newDB returns an instance of DataSource interface.
-// This function returns a error if the connection test fails.
-func newDB(c DataSourceConfig) (DataSource, error) {
+// The drivers registered by RegisterDataSource are created by their factories.
+// This function returns a error if the connection test fails or the ctx is done.
+func newDB(ctx context.Context, c DataSourceConfig) (DataSource, error) {
+	if factory, ok := lookupDataSource(c.Driver); ok {
+		return factory(c)
+	}
 
 	// Join the options into a string of data source name.
 	dataSourceName, err := c.getDataSourceName()
@@ -30,23 +45,88 @@ func newDB(c DataSourceConfig) (DataSource, error) {
 		return nil, xerrors.Errorf("failed to open database: %w", err)
 	}
 
+	configurePool(db, c)
+
 	// Connect to the database and verify its connection.
-	if err = db.Ping(); err != nil {
+	if err = db.PingContext(ctx); err != nil {
 		db.Close()
 
 		return nil, xerrors.Errorf("failed to connect database: %w", err)
 	}
 
-	return &DB{db: db}, nil
+	return &DB{db: db, driver: c.Driver, readOnly: c.ReadOnly}, nil
+}
+
+// poolStats returns the statistics of the connection pool.
+func (d *DB) poolStats() sql.DBStats {
+	return d.db.Stats()
+}
+
+// Ping verifies the connection to the database.
+func (d *DB) Ping(ctx context.Context) error {
+	if err := d.db.PingContext(ctx); err != nil {
+		return xerrors.Errorf("failed to ping database: %w", err)
+	}
+	return nil
+}
+
+// configurePool tunes the connection pool of the database.
+// Zero values leave the defaults of database/sql.
+func configurePool(db *sql.DB, c DataSourceConfig) {
+	if c.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(c.MaxOpenConns)
+	}
+	if c.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(c.MaxIdleConns)
+	}
+	if c.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	}
+	if c.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(c.ConnMaxIdleTime)
+	}
 }
 
 // Get queries the database to generate metrics.
-func (d *DB) Get(rule Rule) (QueryResult, error) {
+// When the context is done, the running query is cancelled on the server.
+func (d *DB) Get(ctx context.Context, rule Rule) (QueryResult, error) {
 	qr := QueryResult{}
 
+	// Pin a connection to identify the running query.
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return qr, xerrors.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	// The lib/pq sends a cancel request to the server by itself,
+	// but the go-sql-driver/mysql only closes the connection.
+	if d.driver == "mysql" {
+		stop, err := d.killQueryOnDone(ctx, conn)
+		if err != nil {
+			return qr, err
+		}
+		defer stop()
+	}
+
+	var q queryer = conn
+	if d.readOnly {
+		tx, err := d.beginReadOnly(ctx, conn)
+		if err != nil {
+			return qr, err
+		}
+		// Nothing is committed, because the transaction only restricts the query.
+		defer func() {
+			if err := tx.Rollback(); err != nil && !xerrors.Is(err, sql.ErrTxDone) {
+				log.Printf("db: failed to rollback: %+v", err)
+			}
+		}()
+		q = tx
+	}
+
 	// Execute the SQL.
 	log.Printf("db: query: %s", rule.Query)
-	rows, err := d.db.Query(rule.Query)
+	rows, err := q.QueryContext(ctx, rule.Query)
 	if err != nil {
 		return qr, xerrors.Errorf("failed to query: %s: %w", rule.Query, err)
 	}
@@ -58,6 +138,15 @@ func (d *DB) Get(rule Rule) (QueryResult, error) {
 		return qr, xerrors.Errorf("failed to get column names: %s: %w", rule.Query, err)
 	}
 
+	// The database types are used to find timestamps returned as bytes.
+	// Some drivers do not report them, and then the names are empty.
+	types := make([]string, len(cols))
+	if cts, err := rows.ColumnTypes(); err == nil {
+		for i, ct := range cts {
+			types[i] = ct.DatabaseTypeName()
+		}
+	}
+
 	// For each rows.
 	for rows.Next() {
 		// At this point, the type of each column is unknown.
@@ -74,7 +163,7 @@ func (d *DB) Get(rule Rule) (QueryResult, error) {
 		}
 
 		// Convert the row to record.
-		record, err := buildRecord(row, cols)
+		record, err := buildRecord(row, cols, types)
 		if err != nil {
 			return qr, err
 		}
@@ -83,38 +172,215 @@ func (d *DB) Get(rule Rule) (QueryResult, error) {
 		qr.Records = append(qr.Records, record)
 	}
 
+	if err := rows.Err(); err != nil {
+		return qr, xerrors.Errorf("failed to iterate rows: %s: %w", rule.Query, err)
+	}
+
 	return qr, nil
 }
 
+// queryer is a connection or a transaction to execute queries.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// beginReadOnly starts a read-only transaction on the connection.
+func (d *DB) beginReadOnly(ctx context.Context, conn *sql.Conn) (*sql.Tx, error) {
+	// The go-sql-driver/mysql starts it by START TRANSACTION READ ONLY.
+	if d.driver == "mysql" {
+		tx, err := conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			return nil, xerrors.Errorf("failed to begin read-only transaction: %w", err)
+		}
+		return tx, nil
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to begin read-only transaction: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "SET TRANSACTION READ ONLY"); err != nil {
+		tx.Rollback()
+		return nil, xerrors.Errorf("failed to set transaction read only: %w", err)
+	}
+	return tx, nil
+}
+
+// killQueryOnDone kills the query running on the connection when the context is done.
+// The returned function must be called after the query finishes to stop watching.
+// It waits for the kill to finish so as not to reuse the connection while killing.
+func (d *DB) killQueryOnDone(ctx context.Context, conn *sql.Conn) (func(), error) {
+	var id int64
+	if err := conn.QueryRowContext(ctx, "SELECT CONNECTION_ID()").Scan(&id); err != nil {
+		return nil, xerrors.Errorf("failed to get connection id: %w", err)
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			// The ctx is already done, so we use another context to kill the query.
+			kctx, cancel := context.WithTimeout(context.Background(), killQueryTimeout)
+			defer cancel()
+
+			log.Printf("db: kill query: connection_id = %d", id)
+			if _, err := d.db.ExecContext(kctx, fmt.Sprintf("KILL QUERY %d", id)); err != nil {
+				log.Printf("db: failed to kill query: connection_id = %d: %+v", id, err)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+	}, nil
+}
+
+// textTimeTypes are the database types of timestamps which may be returned as bytes,
+// such as the columns of MySQL queried over the text protocol.
+var textTimeTypes = map[string]bool{
+	"DATETIME":  true,
+	"TIMESTAMP": true,
+	"DATE":      true,
+}
+
+// mysqlZeroDate is the prefix of the zero value of DATETIME, TIMESTAMP and DATE returned as text.
+const mysqlZeroDate = "0000-00-00"
+
 // buildRecord converts a row to record.
 // The record stores all data as a string to generalize how to handle data at notifications.
-func buildRecord(row []interface{}, cols []string) (Record, error) {
+// The types are the database type names of the columns, which may be empty if unknown.
+func buildRecord(row []interface{}, cols []string, types []string) (Record, error) {
 	record := make(Record, len(cols))
 
 	for i, c := range row {
-		s, err := convertToString(c)
+		// Custom types such as pgtype values are converted to the basic types.
+		v, err := unwrapValuer(c)
 		if err != nil {
-			return record, xerrors.Errorf("failed to convertToString: col = %s, type = %T(%v): %w", cols[i], c, c, err)
+			return record, xerrors.Errorf("failed to get value: col = %s, type = %T(%v): %w", cols[i], c, c, err)
+		}
+
+		// NULL is scanned as nil.
+		// How to handle it depends on the rule, so we keep it as a null value here.
+		if v == nil {
+			record[cols[i]] = Value{Null: true}
+			continue
 		}
 
-		record[cols[i]] = s
+		// Timestamps are kept to be used as epoch seconds or formatted tags.
+		// The zero date of MySQL is not a valid time, so it is handled as NULL
+		// whether it is parsed by parseTime=true or returned as text.
+		if t, ok := v.(time.Time); ok {
+			if t.IsZero() {
+				record[cols[i]] = Value{Null: true}
+				continue
+			}
+			record[cols[i]] = Value{String: t.Format(time.RFC3339), Time: t}
+			continue
+		}
+		if b, ok := v.([]uint8); ok && i < len(types) && textTimeTypes[strings.ToUpper(types[i])] {
+			if strings.HasPrefix(string(b), mysqlZeroDate) {
+				record[cols[i]] = Value{Null: true}
+				continue
+			}
+			if t, ok := parseTextTime(string(b)); ok {
+				record[cols[i]] = Value{String: string(b), Time: t}
+				continue
+			}
+		}
+
+		s, err := convertToString(v)
+		if err != nil {
+			return record, xerrors.Errorf("failed to convertToString: col = %s, type = %T(%v): %w", cols[i], v, v, err)
+		}
+
+		record[cols[i]] = Value{String: s}
 	}
 
 	return record, nil
 }
 
+// parseTextTime parses a timestamp returned as text.
+// The text has no time zone, so it is parsed in UTC as go-sql-driver/mysql does by default.
+// It returns false for values which are not valid times such as the zero date of MySQL.
+func parseTextTime(s string) (time.Time, bool) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// maxValuerDepth is a limit of nested driver.Valuer to unwrap.
+const maxValuerDepth = 8
+
+// unwrapValuer returns the underlying value of driver.Valuer.
+// Other values are returned as they are.
+func unwrapValuer(i interface{}) (interface{}, error) {
+	for n := 0; n < maxValuerDepth; n++ {
+		v, ok := i.(driver.Valuer)
+		if !ok {
+			return i, nil
+		}
+
+		var err error
+		if i, err = v.Value(); err != nil {
+			return nil, err
+		}
+	}
+	return nil, xerrors.Errorf("too deeply nested driver.Valuer: %T", i)
+}
+
 // convertToString casts interface to string.
+// Numbers are formatted without losing precision,
+// and booleans are converted to 1 or 0.
 func convertToString(i interface{}) (string, error) {
 	switch s := i.(type) {
 	case string:
 		return s, nil
 	case []uint8:
+		// NUMERIC and DECIMAL are returned as bytes,
+		// so their precision is kept as it is.
 		return string(s), nil
+	case bool:
+		if s {
+			return "1", nil
+		}
+		return "0", nil
+	case int:
+		return strconv.FormatInt(int64(s), 10), nil
+	case int8:
+		return strconv.FormatInt(int64(s), 10), nil
+	case int16:
+		return strconv.FormatInt(int64(s), 10), nil
+	case int32:
+		return strconv.FormatInt(int64(s), 10), nil
 	case int64:
 		return fmt.Sprintf("%d", s), nil
+	case uint:
+		return strconv.FormatUint(uint64(s), 10), nil
+	case uint8:
+		return strconv.FormatUint(uint64(s), 10), nil
+	case uint16:
+		return strconv.FormatUint(uint64(s), 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(s), 10), nil
+	case uint64:
+		return strconv.FormatUint(s, 10), nil
+	case float32:
+		// Format with 32 bits not to show the error of conversion to float64.
+		return strconv.FormatFloat(float64(s), 'g', -1, 32), nil
 	case float64:
 		// Suppress the trailing zeros.
 		return fmt.Sprintf("%v", s), nil
+	case fmt.Stringer:
+		// Decimal types usually implement String without losing precision.
+		return s.String(), nil
 	default:
 		return "", xerrors.New("failed to cast interface to string")
 	}
@@ -1,7 +1,9 @@
 package cyqldog
 
 import (
+	"context"
 	"database/sql/driver"
+	"fmt"
 	"reflect"
 	"regexp"
 	"testing"
@@ -30,7 +32,7 @@ func TestDBGet(t *testing.T) {
 			mockRows: [][]driver.Value{{int64(3)}},
 			out: QueryResult{
 				Records: []Record{
-					{"count": "3"},
+					{"count": {String: "3"}},
 				},
 			},
 		},
@@ -51,9 +53,30 @@ func TestDBGet(t *testing.T) {
 			},
 			out: QueryResult{
 				Records: []Record{
-					{"tag1": "hoge1", "val1": "1", "tag2": "fuga1", "val2": "0.1"},
-					{"tag1": "hoge1", "val1": "2", "tag2": "fuga2", "val2": "0.2"},
-					{"tag1": "hoge3", "val1": "3", "tag2": "fuga3", "val2": "0.3"},
+					{"tag1": {String: "hoge1"}, "val1": {String: "1"}, "tag2": {String: "fuga1"}, "val2": {String: "0.1"}},
+					{"tag1": {String: "hoge1"}, "val1": {String: "2"}, "tag2": {String: "fuga2"}, "val2": {String: "0.2"}},
+					{"tag1": {String: "hoge3"}, "val1": {String: "3"}, "tag2": {String: "fuga3"}, "val2": {String: "0.3"}},
+				},
+			},
+		},
+		{
+			in: Rule{
+				Name:      "null",
+				Interval:  (10 * time.Second),
+				Query:     "SELECT tag1, val1 FROM table2",
+				Notifier:  "dogstatsd",
+				ValueCols: []string{"val1"},
+				TagCols:   []string{"tag1"},
+			},
+			mockCols: []string{"tag1", "val1"},
+			mockRows: [][]driver.Value{
+				{"hoge1", nil},
+				{nil, int64(2)},
+			},
+			out: QueryResult{
+				Records: []Record{
+					{"tag1": {String: "hoge1"}, "val1": {Null: true}},
+					{"tag1": {Null: true}, "val1": {String: "2"}},
 				},
 			},
 		},
@@ -76,7 +99,7 @@ func TestDBGet(t *testing.T) {
 			}
 			mock.ExpectQuery(regexp.QuoteMeta(tc.in.Query)).WillReturnRows(mockRows)
 
-			got, err := d.Get(tc.in)
+			got, err := d.Get(context.Background(), tc.in)
 
 			if err != nil {
 				t.Errorf("DB.Get(%v) returns unexpected err = %+v", tc.in, err)
@@ -89,3 +112,349 @@ func TestDBGet(t *testing.T) {
 		})
 	}
 }
+
+func TestDBPoolStats(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer mockDB.Close()
+
+	configurePool(mockDB, DataSourceConfig{MaxOpenConns: 3, MaxIdleConns: 1, ConnMaxLifetime: time.Hour})
+	d := &DB{db: mockDB}
+
+	rule := Rule{Name: "test", Query: "SELECT 1 AS count", ValueCols: []string{"count"}}
+	mock.ExpectQuery(regexp.QuoteMeta(rule.Query)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(int64(1)))
+	if _, err := d.Get(context.Background(), rule); err != nil {
+		t.Fatalf("DB.Get(%v) returns unexpected err = %+v", rule, err)
+	}
+
+	stats := d.poolStats()
+	if stats.MaxOpenConnections != 3 {
+		t.Errorf("DB.poolStats() returns max open connections = %d, want = 3", stats.MaxOpenConnections)
+	}
+	if stats.OpenConnections != 1 || stats.Idle != 1 || stats.InUse != 0 {
+		t.Errorf("DB.poolStats() returns %+v, want an idle connection", stats)
+	}
+
+	got := make(map[string]float64)
+	for _, m := range buildPoolMetrics(InternalMetricsConfig{}, "replica", stats) {
+		if !reflect.DeepEqual(m.tags, []string{"data_source:replica"}) {
+			t.Errorf("buildPoolMetrics() returns tags = %v, want = [data_source:replica]", m.tags)
+		}
+		got[m.name] = m.value
+	}
+	want := map[string]float64{
+		"cyqldog.data_source.max_open_connections": 3,
+		"cyqldog.data_source.open_connections":     1,
+		"cyqldog.data_source.idle":                 1,
+		"cyqldog.data_source.in_use":               0,
+	}
+	for name, value := range want {
+		if v, ok := got[name]; !ok || v != value {
+			t.Errorf("buildPoolMetrics() returns %s = %v, want = %v", name, v, value)
+		}
+	}
+}
+
+func TestDBGetReadOnly(t *testing.T) {
+	cases := []struct {
+		driver string
+	}{
+		{driver: "postgres"},
+		{driver: "mysql"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.driver, func(t *testing.T) {
+			mockDB, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to open mock database: %v", err)
+			}
+			defer mockDB.Close()
+
+			d := &DB{db: mockDB, driver: tc.driver, readOnly: true}
+			rule := Rule{Name: "test", Query: "SELECT 1 AS count", ValueCols: []string{"count"}}
+
+			// The query runs in a read-only transaction, which is rolled back.
+			// The go-sql-driver/mysql starts it by the option of BeginTx instead of a statement.
+			if tc.driver == "mysql" {
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT CONNECTION_ID()")).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(int64(42)))
+			}
+			mock.ExpectBegin()
+			if tc.driver != "mysql" {
+				mock.ExpectExec(regexp.QuoteMeta("SET TRANSACTION READ ONLY")).WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+			mock.ExpectQuery(regexp.QuoteMeta(rule.Query)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(int64(1)))
+			mock.ExpectRollback()
+
+			got, err := d.Get(context.Background(), rule)
+			if err != nil {
+				t.Fatalf("DB.Get(%v) returns unexpected err = %+v", rule, err)
+			}
+			if want := (QueryResult{Records: []Record{{"count": {String: "1"}}}}); !reflect.DeepEqual(got, want) {
+				t.Errorf("DB.Get(%v) = %v; want = %v", rule, got, want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("DB.Get(%v) does not run in a read-only transaction: %v", rule, err)
+			}
+		})
+	}
+}
+
+func TestDBGetTimeout(t *testing.T) {
+	cases := []struct {
+		name   string
+		driver string
+		// maxOpenConns is the smallest valid max_open_conns for max_concurrency = 1 if positive.
+		maxOpenConns int
+	}{
+		{name: "postgres", driver: "postgres"},
+		{name: "mysql", driver: "mysql"},
+		{name: "mysql max_open_conns", driver: "mysql", maxOpenConns: 2},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to open mock database: %v", err)
+			}
+			defer mockDB.Close()
+
+			// The query is killed through another connection of the pool.
+			configurePool(mockDB, DataSourceConfig{MaxOpenConns: tc.maxOpenConns})
+			d := &DB{db: mockDB, driver: tc.driver}
+			rule := Rule{
+				Name:  "slow",
+				Query: "SELECT pg_sleep(10)",
+			}
+
+			if tc.driver == "mysql" {
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT CONNECTION_ID()")).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(int64(42)))
+			}
+			mock.ExpectQuery(regexp.QuoteMeta(rule.Query)).
+				WillDelayFor(time.Second).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(int64(1)))
+			if tc.driver == "mysql" {
+				mock.ExpectExec(regexp.QuoteMeta("KILL QUERY 42")).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			if _, err := d.Get(ctx, rule); err == nil {
+				t.Errorf("expected DB.Get(%v) returns timeout error, but err == nil", rule)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("DB.Get(%v) does not meet expectations: %v", rule, err)
+			}
+		})
+	}
+}
+
+// mockNumeric is a mock of custom types such as pgtype.Numeric implementing driver.Valuer.
+type mockNumeric struct {
+	s     string
+	valid bool
+}
+
+// Value implements driver.Valuer for testing.
+func (n mockNumeric) Value() (driver.Value, error) {
+	if !n.valid {
+		return nil, nil
+	}
+	return n.s, nil
+}
+
+// mockDecimal is a mock of decimal types implementing fmt.Stringer.
+type mockDecimal struct {
+	s string
+}
+
+// String implements fmt.Stringer for testing.
+func (d mockDecimal) String() string {
+	return d.s
+}
+
+func TestBuildRecordPostgres(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("JST", 9*60*60))
+
+	// The types returned by lib/pq.
+	cases := []struct {
+		name string
+		in   interface{}
+		out  Value
+	}{
+		{name: "integer", in: int64(-42), out: Value{String: "-42"}},
+		{name: "bigint", in: int64(9223372036854775807), out: Value{String: "9223372036854775807"}},
+		{name: "double precision", in: float64(0.1), out: Value{String: "0.1"}},
+		{name: "numeric", in: []byte("12345678901234567890.123456789"), out: Value{String: "12345678901234567890.123456789"}},
+		{name: "text", in: "hoge", out: Value{String: "hoge"}},
+		{name: "boolean true", in: true, out: Value{String: "1"}},
+		{name: "boolean false", in: false, out: Value{String: "0"}},
+		{name: "timestamptz", in: ts, out: Value{String: "2024-01-02T03:04:05+09:00", Time: ts}},
+		{name: "zero timestamp", in: time.Time{}, out: Value{Null: true}},
+		{name: "null", in: nil, out: Value{Null: true}},
+		{name: "pgtype numeric", in: mockNumeric{s: "1.50", valid: true}, out: Value{String: "1.50"}},
+		{name: "pgtype null", in: mockNumeric{}, out: Value{Null: true}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := buildRecord([]interface{}{tc.in}, []string{"col"}, nil)
+			if err != nil {
+				t.Fatalf("buildRecord(%T(%v)) returns unexpected err = %+v", tc.in, tc.in, err)
+			}
+			if !reflect.DeepEqual(got["col"], tc.out) {
+				t.Errorf("buildRecord(%T(%v)) = %+v, want = %+v", tc.in, tc.in, got["col"], tc.out)
+			}
+		})
+	}
+}
+
+func TestBuildRecordMySQL(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// The types returned by go-sql-driver/mysql.
+	// The text protocol returns bytes for most types,
+	// and the binary protocol returns the typed values.
+	cases := []struct {
+		name string
+		in   interface{}
+		typ  string
+		out  Value
+	}{
+		{name: "int text", in: []byte("42"), typ: "INT", out: Value{String: "42"}},
+		{name: "int binary", in: int64(42), typ: "INT", out: Value{String: "42"}},
+		{name: "int unsigned binary", in: uint32(4294967295), typ: "UNSIGNED INT", out: Value{String: "4294967295"}},
+		{name: "bigint unsigned binary", in: uint64(18446744073709551615), typ: "UNSIGNED BIGINT", out: Value{String: "18446744073709551615"}},
+		{name: "tinyint binary", in: int8(-1), typ: "TINYINT", out: Value{String: "-1"}},
+		{name: "smallint binary", in: int16(-300), typ: "SMALLINT", out: Value{String: "-300"}},
+		{name: "mediumint binary", in: int32(-70000), typ: "MEDIUMINT", out: Value{String: "-70000"}},
+		{name: "float binary", in: float32(0.1), typ: "FLOAT", out: Value{String: "0.1"}},
+		{name: "double binary", in: float64(0.25), typ: "DOUBLE", out: Value{String: "0.25"}},
+		{name: "decimal", in: []byte("0.1000000000000000000000000001"), typ: "DECIMAL", out: Value{String: "0.1000000000000000000000000001"}},
+		{name: "datetime text", in: []byte("2024-01-02 03:04:05"), typ: "DATETIME", out: Value{String: "2024-01-02 03:04:05", Time: ts}},
+		{name: "timestamp text", in: []byte("2024-01-02 03:04:05.000000"), typ: "TIMESTAMP", out: Value{String: "2024-01-02 03:04:05.000000", Time: ts}},
+		{name: "date text", in: []byte("2024-01-02"), typ: "DATE", out: Value{String: "2024-01-02", Time: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}},
+		{name: "zero datetime text", in: []byte("0000-00-00 00:00:00"), typ: "DATETIME", out: Value{Null: true}},
+		{name: "zero date text", in: []byte("0000-00-00"), typ: "DATE", out: Value{Null: true}},
+		{name: "datetime text unknown type", in: []byte("2024-01-02 03:04:05"), out: Value{String: "2024-01-02 03:04:05"}},
+		{name: "datetime parseTime", in: ts, typ: "DATETIME", out: Value{String: "2024-01-02T03:04:05Z", Time: ts}},
+		{name: "zero datetime parseTime", in: time.Time{}, typ: "DATETIME", out: Value{Null: true}},
+		{name: "varchar", in: []byte("hoge"), typ: "VARCHAR", out: Value{String: "hoge"}},
+		{name: "varchar like datetime", in: []byte("2024-01-02 03:04:05"), typ: "VARCHAR", out: Value{String: "2024-01-02 03:04:05"}},
+		{name: "null", in: nil, typ: "DATETIME", out: Value{Null: true}},
+		{name: "decimal type", in: mockDecimal{s: "3.14159265358979323846"}, typ: "DECIMAL", out: Value{String: "3.14159265358979323846"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := buildRecord([]interface{}{tc.in}, []string{"col"}, []string{tc.typ})
+			if err != nil {
+				t.Fatalf("buildRecord(%T(%v)) returns unexpected err = %+v", tc.in, tc.in, err)
+			}
+			if !reflect.DeepEqual(got["col"], tc.out) {
+				t.Errorf("buildRecord(%T(%v)) = %+v, want = %+v", tc.in, tc.in, got["col"], tc.out)
+			}
+		})
+	}
+}
+
+func TestDBGetTimes(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	rule := Rule{
+		Name:       "times",
+		Query:      "SELECT created_at, count FROM table1",
+		ValueCols:  []string{"count"},
+		TagCols:    []string{"created_at"},
+		TimeFormat: "2006-01-02",
+	}
+
+	// The values are returned through the driver as the real drivers return them.
+	// The zero time such as the zero date of MySQL is NULL.
+	// Note that sqlmock does not report the database types,
+	// so DATETIME returned as bytes is covered by TestBuildRecordMySQL.
+	cases := []struct {
+		driver string
+		in     driver.Value
+		out    Value
+		tags   []string
+	}{
+		{
+			driver: "postgres",
+			in:     ts,
+			out:    Value{String: "2024-01-02T03:04:05Z", Time: ts},
+			tags:   []string{"created_at:2024-01-02"},
+		},
+		{
+			driver: "postgres",
+			in:     time.Time{},
+			out:    Value{Null: true},
+			tags:   []string{"created_at:null"},
+		},
+		{
+			driver: "mysql",
+			in:     ts,
+			out:    Value{String: "2024-01-02T03:04:05Z", Time: ts},
+			tags:   []string{"created_at:2024-01-02"},
+		},
+		{
+			driver: "mysql",
+			in:     time.Time{},
+			out:    Value{Null: true},
+			tags:   []string{"created_at:null"},
+		},
+		{
+			driver: "mysql",
+			in:     []byte("2024-01-02 03:04:05"),
+			out:    Value{String: "2024-01-02 03:04:05"},
+			tags:   []string{"created_at:2024-01-02 03:04:05"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s %v", tc.driver, tc.in), func(t *testing.T) {
+			mockDB, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to open mock database: %v", err)
+			}
+			defer mockDB.Close()
+
+			d := &DB{db: mockDB, driver: tc.driver}
+
+			if tc.driver == "mysql" {
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT CONNECTION_ID()")).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(int64(42)))
+			}
+			mock.ExpectQuery(regexp.QuoteMeta(rule.Query)).
+				WillReturnRows(sqlmock.NewRows([]string{"created_at", "count"}).AddRow(tc.in, int64(1)))
+
+			got, err := d.Get(context.Background(), rule)
+			if err != nil {
+				t.Fatalf("DB.Get(%v) returns unexpected err = %+v", rule, err)
+			}
+			if len(got.Records) != 1 {
+				t.Fatalf("DB.Get(%v) returns %d records, want = 1", rule, len(got.Records))
+			}
+			if !reflect.DeepEqual(got.Records[0]["created_at"], tc.out) {
+				t.Errorf("DB.Get(%v) = %+v, want = %+v", rule, got.Records[0]["created_at"], tc.out)
+			}
+			if tags := buildTags(got.Records[0], rule); !reflect.DeepEqual(tags, tc.tags) {
+				t.Errorf("buildTags(%+v) = %v, want = %v", got.Records[0], tags, tc.tags)
+			}
+		})
+	}
+}
+
+func TestBuildRecordUnsupported(t *testing.T) {
+	in := struct{ x int }{x: 1}
+	if _, err := buildRecord([]interface{}{in}, []string{"col"}, nil); err == nil {
+		t.Errorf("expected buildRecord(%T(%v)) returns error, but err == nil", in, in)
+	}
+}
@@ -0,0 +1,268 @@
+package cyqldog
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// defaultErrorEventsBackoff is a default wait before the first retry of an error event.
+const defaultErrorEventsBackoff = time.Second
+
+// backoff returns the backoff with the default applied.
+func (c ErrorEventsConfig) backoff() time.Duration {
+	if c.Backoff == 0 {
+		return defaultErrorEventsBackoff
+	}
+	return c.Backoff
+}
+
+// eventQueueSize is the number of events which can wait to be sent in the background.
+const eventQueueSize = 1000
+
+// eventDelivery sends error events so that a failing notifier does not stop the monitor.
+// An event which cannot be sent is retried with backoff, then sent to the fallback notifier,
+// and finally written to the dead letter file or the log.
+// Once started, the events are sent by a worker in the background,
+// so that the retries do not block the checkers.
+type eventDelivery struct {
+	config    ErrorEventsConfig
+	notifiers Notifiers
+	internal  InternalMetricsConfig
+
+	// failed is the number of events which could not be sent to their notifiers.
+	failed atomic.Int64
+
+	// qmu guards the queue against being closed while sending.
+	qmu sync.Mutex
+	// queue is the events to be sent by the worker, or nil if it is not running.
+	queue chan queuedEvent
+	// done is closed when the worker has sent all the queued events.
+	done chan struct{}
+
+	// mu serializes writes to the dead letter file.
+	mu sync.Mutex
+}
+
+// queuedEvent is an event waiting to be sent to the notifier of the name.
+type queuedEvent struct {
+	name  string
+	event *Event
+	// err is the failure of the notifier if the event skips it and goes to the fallback.
+	err error
+}
+
+// newEventDelivery returns an instance of eventDelivery.
+// The events are sent synchronously until start is called.
+func newEventDelivery(c ErrorEventsConfig, notifiers Notifiers, internal InternalMetricsConfig) *eventDelivery {
+	return &eventDelivery{
+		config:    c,
+		notifiers: notifiers,
+		internal:  internal,
+	}
+}
+
+// start runs the worker to send the events in the background.
+func (d *eventDelivery) start() {
+	d.qmu.Lock()
+	defer d.qmu.Unlock()
+	if d.queue != nil {
+		return
+	}
+
+	q := make(chan queuedEvent, eventQueueSize)
+	done := make(chan struct{})
+	d.queue = q
+	d.done = done
+
+	go func() {
+		defer close(done)
+		// The retries are limited, so the queued events are sent even while stopping.
+		for e := range q {
+			d.deliver(context.Background(), e)
+		}
+	}()
+}
+
+// stop waits for the worker to send the queued events.
+// The events sent afterwards are sent synchronously.
+func (d *eventDelivery) stop() {
+	d.qmu.Lock()
+	q, done := d.queue, d.done
+	d.queue = nil
+	d.qmu.Unlock()
+
+	if q == nil {
+		return
+	}
+	close(q)
+	<-done
+}
+
+// deadLetter is a line of the dead letter file.
+type deadLetter struct {
+	Time     time.Time `json:"time"`
+	Notifier string    `json:"notifier"`
+	Error    string    `json:"error"`
+	Title    string    `json:"title"`
+	Text     string    `json:"text"`
+	Level    string    `json:"level"`
+	Tags     []string  `json:"tags"`
+}
+
+// send sends the event to the notifier of the name through the fallback chain.
+// If the worker is running, the event is queued and the ctx is not used.
+// When the queue is full, the event is written to the dead letter file without waiting.
+// It never fails, but the failure is counted.
+func (d *eventDelivery) send(ctx context.Context, name string, event *Event) {
+	d.enqueue(ctx, queuedEvent{name: name, event: event})
+}
+
+// sendFallback sends the event for the notifier of the name which already failed with the err
+// to the fallback notifier, or writes it to the dead letter file.
+// The event is counted as a failure of the notifier.
+func (d *eventDelivery) sendFallback(ctx context.Context, name string, event *Event, err error) {
+	d.enqueue(ctx, queuedEvent{name: name, event: event, err: err})
+}
+
+// enqueue queues the event for the worker, or delivers it synchronously if the worker is not running.
+func (d *eventDelivery) enqueue(ctx context.Context, e queuedEvent) {
+	d.qmu.Lock()
+	if d.queue != nil {
+		select {
+		case d.queue <- e:
+			d.qmu.Unlock()
+		default:
+			d.qmu.Unlock()
+			err := xerrors.Errorf("event queue is full: size = %d", eventQueueSize)
+			d.fail(ctx, e.name, err)
+			d.writeDeadLetter(e.name, e.event, err)
+		}
+		return
+	}
+	d.qmu.Unlock()
+
+	d.deliver(ctx, e)
+}
+
+// deliver sends the event to the notifier through the fallback chain.
+// The notifier is skipped if it already failed.
+func (d *eventDelivery) deliver(ctx context.Context, e queuedEvent) {
+	name, event, err := e.name, e.event, e.err
+	if err == nil {
+		err = d.retry(ctx, name, event)
+		if err == nil {
+			return
+		}
+	}
+	d.fail(ctx, name, err)
+
+	if fallback := d.config.Fallback; len(fallback) > 0 && fallback != name {
+		ferr := d.retry(ctx, fallback, event)
+		if ferr == nil {
+			log.Printf("delivery: sent event to fallback notifier: %s", fallback)
+			return
+		}
+		log.Printf("delivery: failed to send event to fallback notifier: %s: %+v", fallback, ferr)
+	}
+
+	d.writeDeadLetter(name, event, err)
+}
+
+// retry sends the event to the notifier, retrying with exponential backoff on failure.
+func (d *eventDelivery) retry(ctx context.Context, name string, event *Event) error {
+	notifier, ok := d.notifiers[name]
+	if !ok {
+		return xerrors.Errorf("unknown notifier: %s", name)
+	}
+
+	backoff := d.config.backoff()
+	for i := 0; ; i++ {
+		err := notifier.Event(ctx, event)
+		if err == nil {
+			return nil
+		}
+		if i >= d.config.Retries {
+			return err
+		}
+
+		log.Printf("delivery: retry in %s: notifier = %s: %+v", backoff, name, err)
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return xerrors.Errorf("failed to retry event: %w", ctx.Err())
+		}
+		backoff *= 2
+	}
+}
+
+// writeDeadLetter appends the undelivered event to the dead letter file.
+// If the file is not configured or cannot be written, the event is logged instead.
+func (d *eventDelivery) writeDeadLetter(name string, event *Event, err error) {
+	line, merr := json.Marshal(deadLetter{
+		Time:     time.Now(),
+		Notifier: name,
+		Error:    err.Error(),
+		Title:    event.Title,
+		Text:     event.Text,
+		Level:    event.Level,
+		Tags:     event.Tags,
+	})
+	if merr != nil {
+		log.Printf("delivery: dead letter: %s: %s", event.Title, event.Text)
+		return
+	}
+
+	if len(d.config.DeadLetter) == 0 {
+		log.Printf("delivery: dead letter: %s", line)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f, ferr := os.OpenFile(d.config.DeadLetter, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if ferr == nil {
+		_, ferr = f.Write(append(line, '\n'))
+		if cerr := f.Close(); ferr == nil {
+			ferr = cerr
+		}
+	}
+	if ferr != nil {
+		log.Printf("delivery: failed to write dead letter: %s: %+v", d.config.DeadLetter, ferr)
+		log.Printf("delivery: dead letter: %s", line)
+	}
+}
+
+// fail counts the event which could not be sent to the notifier,
+// and sends the count as an internal metric through the notifiers.
+func (d *eventDelivery) fail(ctx context.Context, name string, err error) {
+	d.failed.Add(1)
+	log.Printf("delivery: failed to send event: notifier = %s: %+v", name, err)
+
+	if d.internal.Disabled {
+		return
+	}
+
+	m := metric{name: d.internal.namespace() + ".events.failed", value: 1, tags: []string{"notifier:" + name}, kind: MetricCount}
+	for _, n := range d.notifiers.names() {
+		if mn, ok := d.notifiers[n].(metricsNotifier); ok {
+			if err := mn.putMetrics(ctx, []metric{m}); err != nil {
+				log.Printf("delivery: failed to send metrics: notifier = %s: %+v", n, err)
+			}
+		}
+	}
+}
+
+// failures returns the number of events which could not be sent to their notifiers.
+func (d *eventDelivery) failures() int64 {
+	return d.failed.Load()
+}
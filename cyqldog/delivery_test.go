@@ -0,0 +1,172 @@
+package cyqldog
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+func TestEventDeliverySend(t *testing.T) {
+	cases := []struct {
+		name       string
+		primaryErr error
+		fallback   string
+		fallbackOK bool
+		// calls is the number of the calls of the primary notifier.
+		calls int
+		// failures is the number of the failed deliveries.
+		failures int64
+		// fallbackEvents is the number of the events sent to the fallback notifier.
+		fallbackEvents int
+		deadLetter     bool
+	}{
+		{
+			name:       "sent",
+			primaryErr: nil,
+			calls:      1,
+			failures:   0,
+		},
+		{
+			name:           "fallback",
+			primaryErr:     xerrors.New("connection refused"),
+			fallback:       "fallback",
+			fallbackOK:     true,
+			calls:          3,
+			failures:       1,
+			fallbackEvents: 1,
+		},
+		{
+			name:       "dead letter",
+			primaryErr: xerrors.New("connection refused"),
+			fallback:   "fallback",
+			fallbackOK: false,
+			calls:      3,
+			failures:   1,
+			deadLetter: true,
+		},
+		{
+			name:       "dead letter without fallback",
+			primaryErr: xerrors.New("connection refused"),
+			calls:      3,
+			failures:   1,
+			deadLetter: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			primary := &mockNotifier{eventErr: tc.primaryErr}
+			fallback := &mockNotifier{}
+			if !tc.fallbackOK {
+				fallback.eventErr = xerrors.New("fallback is also down")
+			}
+			path := filepath.Join(t.TempDir(), "dead_letter.jsonl")
+			config := ErrorEventsConfig{Retries: 2, Backoff: time.Millisecond, Fallback: tc.fallback, DeadLetter: path}
+			d := newEventDelivery(config, Notifiers{"primary": primary, "fallback": fallback}, InternalMetricsConfig{})
+
+			event := &Event{Title: "cyqldog: test", Level: "error", Tags: []string{"rule:test"}}
+			d.send(context.Background(), "primary", event)
+
+			if primary.eventCalls != tc.calls {
+				t.Errorf("eventDelivery.send() calls the primary notifier %d times, want = %d", primary.eventCalls, tc.calls)
+			}
+			if got := d.failures(); got != tc.failures {
+				t.Errorf("eventDelivery.failures() = %d, want = %d", got, tc.failures)
+			}
+			failed := 0
+			for _, m := range primary.metrics {
+				if m.name == "cyqldog.events.failed" && m.kind == MetricCount && m.value == 1 {
+					failed++
+				}
+			}
+			if int64(failed) != tc.failures {
+				t.Errorf("eventDelivery.send() sends %d failed counts = %+v, want = %d", failed, primary.metrics, tc.failures)
+			}
+			if len(fallback.events) != tc.fallbackEvents {
+				t.Errorf("eventDelivery.send() sends %d events to the fallback notifier, want = %d", len(fallback.events), tc.fallbackEvents)
+			}
+
+			buf, err := os.ReadFile(path)
+			if !tc.deadLetter {
+				if err == nil {
+					t.Errorf("eventDelivery.send() writes the dead letter = %s, want none", buf)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to read the dead letter: %v", err)
+			}
+			var dl deadLetter
+			if err := json.Unmarshal(buf, &dl); err != nil {
+				t.Fatalf("failed to parse the dead letter: %s: %v", buf, err)
+			}
+			if dl.Notifier != "primary" || dl.Title != event.Title || dl.Error != "connection refused" {
+				t.Errorf("eventDelivery.send() writes the dead letter = %+v", dl)
+			}
+		})
+	}
+}
+
+// blockingNotifier is a mock of Notifier whose Event blocks until released.
+type blockingNotifier struct {
+	mockNotifier
+	// started receives a value when Event is called.
+	started chan struct{}
+	// release is closed to return from Event.
+	release chan struct{}
+}
+
+// Event implements an interface of Notifier for testing.
+func (n *blockingNotifier) Event(ctx context.Context, e *Event) error {
+	select {
+	case n.started <- struct{}{}:
+	default:
+	}
+	<-n.release
+	return n.mockNotifier.Event(ctx, e)
+}
+
+func TestEventDeliveryStart(t *testing.T) {
+	n := &blockingNotifier{started: make(chan struct{}, 1), release: make(chan struct{})}
+	path := filepath.Join(t.TempDir(), "dead_letter.jsonl")
+	d := newEventDelivery(ErrorEventsConfig{DeadLetter: path}, Notifiers{"slow": n}, InternalMetricsConfig{})
+	d.start()
+
+	// The worker is blocked by the first event, and the following ones are queued.
+	event := &Event{Title: "cyqldog: test", Level: "error"}
+	d.send(context.Background(), "slow", event)
+	<-n.started
+	for i := 0; i < eventQueueSize; i++ {
+		d.send(context.Background(), "slow", event)
+	}
+
+	// The event is not queued but written to the dead letter file without waiting.
+	d.send(context.Background(), "slow", event)
+	if got := d.failures(); got != 1 {
+		t.Errorf("eventDelivery.failures() = %d, want = 1 for the full queue", got)
+	}
+	if buf, err := os.ReadFile(path); err != nil {
+		t.Errorf("eventDelivery.send() does not write the dead letter for the full queue: %v", err)
+	} else if !strings.Contains(string(buf), "event queue is full") {
+		t.Errorf("eventDelivery.send() writes the dead letter = %s, want the full queue", buf)
+	}
+
+	// The queued events are sent before stopping.
+	close(n.release)
+	d.stop()
+	if got := len(n.events); got != eventQueueSize+1 {
+		t.Errorf("eventDelivery.stop() returns after %d events are sent, want = %d", got, eventQueueSize+1)
+	}
+
+	// The events are sent synchronously after stopping.
+	d.send(context.Background(), "slow", event)
+	if got := len(n.events); got != eventQueueSize+2 {
+		t.Errorf("eventDelivery.send() after stop sends %d events in total, want = %d", got, eventQueueSize+2)
+	}
+}
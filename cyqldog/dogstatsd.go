@@ -1,9 +1,12 @@
 package cyqldog
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"math"
 	"strconv"
+	"time"
 
 	"golang.org/x/xerrors"
 
@@ -26,7 +29,12 @@ type DogstatsdConfig struct {
 // We make a layer of abstraction for testing.
 type statsdClient interface {
 	Gauge(name string, value float64, tags []string, rate float64) error
+	Count(name string, value int64, tags []string, rate float64) error
+	Histogram(name string, value float64, tags []string, rate float64) error
+	Distribution(name string, value float64, tags []string, rate float64) error
+	Set(name string, value string, tags []string, rate float64) error
 	Event(e *statsd.Event) error
+	ServiceCheck(sc *statsd.ServiceCheck) error
 }
 
 // Dogstatsd is a configuration of the dogstatsd to connect.
@@ -48,7 +56,7 @@ func newDogstatsd(d DogstatsdConfig) (Notifier, error) {
 }
 
 // Event send an event to the dogstatsd.
-func (d *Dogstatsd) Event(e *Event) error {
+func (d *Dogstatsd) Event(ctx context.Context, e *Event) error {
 	se := &statsd.Event{
 		Title:          e.Title,
 		Text:           e.Text,
@@ -76,7 +84,7 @@ func (d *Dogstatsd) Event(e *Event) error {
 }
 
 // Put sends metrics to the dogstatsd.
-func (d *Dogstatsd) Put(qr QueryResult, rule Rule) error {
+func (d *Dogstatsd) Put(ctx context.Context, qr QueryResult, rule Rule) error {
 	// convert to the query result to metrics.
 	metrics, err := buildMetricsForQueryResult(qr, rule)
 	if err != nil {
@@ -85,13 +93,72 @@ func (d *Dogstatsd) Put(qr QueryResult, rule Rule) error {
 
 	// For each metric.
 	for _, metric := range metrics {
+		// Stop sending if the check is cancelled.
+		if err := ctx.Err(); err != nil {
+			return xerrors.Errorf("failed to put metrics: %w", err)
+		}
+
 		log.Printf("checker: put: %s(%s) = %v\n", metric.name, metric.tags, metric.value)
 
 		// Send a metic to the dogstatsd.
-		err := d.client.Gauge(metric.name, metric.value, metric.tags, 1)
-		if err != nil {
-			return xerrors.Errorf("failed to gauge statsd for name = %s, value = %v, tags = %v: %w", metric.name, metric.value, metric.tags, err)
+		if err := d.send(metric); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putMetrics sends the internal metrics to the dogstatsd.
+func (d *Dogstatsd) putMetrics(ctx context.Context, metrics []metric) error {
+	for _, m := range metrics {
+		if err := d.send(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serviceCheck sends a service check to the dogstatsd.
+func (d *Dogstatsd) serviceCheck(ctx context.Context, name string, ok bool, tags []string, message string) error {
+	sc := statsd.NewServiceCheck(name, statsd.Ok)
+	if !ok {
+		sc.Status = statsd.Critical
+	}
+	sc.Tags = tags
+	sc.Message = message
+
+	if err := d.client.ServiceCheck(sc); err != nil {
+		return xerrors.Errorf("failed to send statsd service check for name = %s: %w", name, err)
+	}
+	return nil
+}
+
+// send calls the statsd method of the type of the metric.
+func (d *Dogstatsd) send(m metric) error {
+	rate := m.rate
+	if rate == 0 {
+		rate = 1
+	}
+
+	var err error
+	switch m.kind {
+	case MetricCount:
+		// The statsd count takes an integer, so a fractional value is not truncated silently.
+		if math.IsInf(m.value, 0) || m.value != math.Trunc(m.value) {
+			return xerrors.Errorf("statsd count must be an integer: name = %s, value = %v, tags = %v", m.name, m.value, m.tags)
 		}
+		err = d.client.Count(m.name, int64(m.value), m.tags, rate)
+	case MetricHistogram:
+		err = d.client.Histogram(m.name, m.value, m.tags, rate)
+	case MetricDistribution:
+		err = d.client.Distribution(m.name, m.value, m.tags, rate)
+	case MetricSet:
+		err = d.client.Set(m.name, strconv.FormatFloat(m.value, 'f', -1, 64), m.tags, rate)
+	default:
+		err = d.client.Gauge(m.name, m.value, m.tags, rate)
+	}
+	if err != nil {
+		return xerrors.Errorf("failed to send statsd %s for name = %s, value = %v, tags = %v: %w", m.kind, m.name, m.value, m.tags, err)
 	}
 	return nil
 }
@@ -102,7 +169,7 @@ func buildMetricsForQueryResult(qr QueryResult, rule Rule) ([]metric, error) {
 
 	for _, record := range qr.Records {
 		// convert record to metrics.
-		ms, err := buildMetricsForRecord(record, rule.Name, rule.ValueCols, rule.TagCols)
+		ms, err := buildMetricsForRecord(record, rule)
 		if err != nil {
 			return metrics, err
 		}
@@ -114,22 +181,37 @@ func buildMetricsForQueryResult(qr QueryResult, rule Rule) ([]metric, error) {
 }
 
 // buildMetricsForRecord returns a metrics from the record.
-func buildMetricsForRecord(record Record, prefix string, valueCols []string, tagCols []string) ([]metric, error) {
+// NULL values are handled according to the null value policy of the rule.
+func buildMetricsForRecord(record Record, rule Rule) ([]metric, error) {
 	metrics := []metric{}
 
-	for _, vc := range valueCols {
-		// The value of record is a string for general purpose,
-		// so we parse and convert it to float64 here.
-		value, err := strconv.ParseFloat(record[vc], 64)
-		if err != nil {
-			return metrics, xerrors.Errorf("failed to ParseFloat: col = %s, type = %T(%v): %w", vc, record[vc], record[vc], err)
+	for _, vc := range rule.ValueCols {
+		var value float64
+		if record[vc].Null {
+			switch rule.NullValue.policy() {
+			case NullValueSkip:
+				continue
+			case NullValueDefault:
+				value = rule.NullValue.Default
+			default:
+				return metrics, xerrors.Errorf("value is NULL: rule = %s, col = %s", rule.Name, vc)
+			}
+		} else {
+			v, err := parseValue(record[vc])
+			if err != nil {
+				return metrics, xerrors.Errorf("failed to ParseFloat: col = %s, type = %T(%v): %w", vc, record[vc].String, record[vc].String, err)
+			}
+			value = v
 		}
 
 		// build metric.
+		vt := rule.ValueTypes[vc]
 		m := metric{
-			name:  prefix + "." + vc, // prefix is Rule.Name
+			name:  rule.Name + "." + vc,
 			value: value,
-			tags:  buildTags(record, tagCols),
+			tags:  buildTags(record, rule),
+			kind:  vt.Type,
+			rate:  vt.SampleRate,
 		}
 		metrics = append(metrics, m)
 	}
@@ -137,13 +219,55 @@ func buildMetricsForRecord(record Record, prefix string, valueCols []string, tag
 	return metrics, nil
 }
 
+// timeLayouts are layouts of timestamps returned as strings.
+// For example, go-sql-driver/mysql returns DATETIME as bytes without parseTime=true.
+var timeLayouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	time.RFC3339Nano,
+	"2006-01-02",
+}
+
+// parseValue converts the value to float64.
+// Timestamps are converted to epoch seconds.
+func parseValue(v Value) (float64, error) {
+	if !v.Time.IsZero() {
+		return epochSeconds(v.Time), nil
+	}
+
+	// The value of record is a string for general purpose,
+	// so we parse and convert it to float64 here.
+	f, err := strconv.ParseFloat(v.String, 64)
+	if err == nil {
+		return f, nil
+	}
+
+	if t, ok := parseTextTime(v.String); ok {
+		return epochSeconds(t), nil
+	}
+	return 0, err
+}
+
+// epochSeconds returns the seconds elapsed since the Unix epoch.
+func epochSeconds(t time.Time) float64 {
+	return float64(t.Unix()) + float64(t.Nanosecond())/1e9
+}
+
 // buildTags returns a slice of tags from the record and column names to use for tag.
-func buildTags(record Record, tagCols []string) []string {
+// NULL values are replaced with the placeholder, and timestamps are formatted.
+func buildTags(record Record, rule Rule) []string {
 	tags := []string{}
 
-	for _, tc := range tagCols {
+	for _, tc := range rule.TagCols {
+		v := record[tc].String
+		switch {
+		case record[tc].Null:
+			v = rule.nullTag()
+		case !record[tc].Time.IsZero():
+			v = record[tc].Time.Format(rule.timeFormat())
+		}
+
 		// tags are formatted as column name:value.
-		tags = append(tags, tc+":"+record[tc])
+		tags = append(tags, tc+":"+v)
 	}
 
 	return tags
@@ -1,6 +1,7 @@
 package cyqldog
 
 import (
+	"context"
 	"reflect"
 	"testing"
 	"time"
@@ -12,6 +13,7 @@ import (
 type mockStatsdClient struct {
 	metrics []mockStatsdMetric
 	events  []statsd.Event
+	checks  []statsd.ServiceCheck
 }
 
 // mockStatsdMetric records the method name and arguments of the mockStatsdClient's API call for testing.
@@ -37,6 +39,62 @@ func (c *mockStatsdClient) Gauge(name string, value float64, tags []string, rate
 	return nil
 }
 
+// Count implements an interface of statsdClient for testing.
+// It only records API calls and does not call real dogstatsd.
+func (c *mockStatsdClient) Count(name string, value int64, tags []string, rate float64) error {
+	metric := mockStatsdMetric{
+		method: "count",
+		name:   name,
+		value:  value,
+		tags:   tags,
+		rate:   rate,
+	}
+	c.metrics = append(c.metrics, metric)
+	return nil
+}
+
+// Histogram implements an interface of statsdClient for testing.
+// It only records API calls and does not call real dogstatsd.
+func (c *mockStatsdClient) Histogram(name string, value float64, tags []string, rate float64) error {
+	metric := mockStatsdMetric{
+		method: "histogram",
+		name:   name,
+		value:  value,
+		tags:   tags,
+		rate:   rate,
+	}
+	c.metrics = append(c.metrics, metric)
+	return nil
+}
+
+// Distribution implements an interface of statsdClient for testing.
+// It only records API calls and does not call real dogstatsd.
+func (c *mockStatsdClient) Distribution(name string, value float64, tags []string, rate float64) error {
+	metric := mockStatsdMetric{
+		method: "distribution",
+		name:   name,
+		value:  value,
+		tags:   tags,
+		rate:   rate,
+	}
+	c.metrics = append(c.metrics, metric)
+	return nil
+}
+
+// Set implements an interface of statsdClient for testing.
+// It only records API calls and does not call real dogstatsd.
+func (c *mockStatsdClient) Set(name string, value string, tags []string, rate float64) error {
+	metric := mockStatsdMetric{
+		method: "set",
+		name:   name,
+		value:  value,
+		tags:   tags,
+		rate:   rate,
+	}
+	c.metrics = append(c.metrics, metric)
+	return nil
+}
+
 // Event implements an interface of statsdClient for testing.
 // It only records API calls and does not call real dogstatsd.
 func (c *mockStatsdClient) Event(e *statsd.Event) error {
@@ -44,6 +102,12 @@ func (c *mockStatsdClient) Event(e *statsd.Event) error {
 	return nil
 }
 
+// ServiceCheck implements an interface of statsdClient for testing.
+func (c *mockStatsdClient) ServiceCheck(sc *statsd.ServiceCheck) error {
+	c.checks = append(c.checks, *sc)
+	return nil
+}
+
 func newMockStatsdClient() *mockStatsdClient {
 	return &mockStatsdClient{}
 }
@@ -61,7 +125,7 @@ func TestDogstatsdPut(t *testing.T) {
 		{
 			qr: QueryResult{
 				Records: []Record{
-					{"count": "3"},
+					{"count": {String: "3"}},
 				},
 			},
 			rule: Rule{
@@ -79,9 +143,9 @@ func TestDogstatsdPut(t *testing.T) {
 		{
 			qr: QueryResult{
 				Records: []Record{
-					{"tag1": "hoge1", "val1": "1", "tag2": "fuga1", "val2": "0.1"},
-					{"tag1": "hoge1", "val1": "2", "tag2": "fuga2", "val2": "0.2"},
-					{"tag1": "hoge3", "val1": "3", "tag2": "fuga3", "val2": "0.3"},
+					{"tag1": {String: "hoge1"}, "val1": {String: "1"}, "tag2": {String: "fuga1"}, "val2": {String: "0.1"}},
+					{"tag1": {String: "hoge1"}, "val1": {String: "2"}, "tag2": {String: "fuga2"}, "val2": {String: "0.2"}},
+					{"tag1": {String: "hoge3"}, "val1": {String: "3"}, "tag2": {String: "fuga3"}, "val2": {String: "0.3"}},
 				},
 			},
 			rule: Rule{
@@ -101,13 +165,42 @@ func TestDogstatsdPut(t *testing.T) {
 				{method: "gauge", name: "test2.val2", value: float64(0.3), tags: []string{"tag1:hoge3", "tag2:fuga3"}, rate: 1},
 			},
 		},
+		{
+			qr: QueryResult{
+				Records: []Record{
+					{"gauge": {String: "1"}, "count": {String: "2"}, "histogram": {String: "0.3"}, "distribution": {String: "0.4"}, "set": {String: "5"}},
+				},
+			},
+			rule: Rule{
+				Name:      "test3",
+				Interval:  (10 * time.Second),
+				Query:     "SELECT gauge, count, histogram, distribution, set FROM table1",
+				Notifier:  "dogstatsd",
+				ValueCols: []string{"gauge", "count", "histogram", "distribution", "set"},
+				ValueTypes: map[string]ValueType{
+					"gauge":        {Type: MetricGauge},
+					"count":        {Type: MetricCount},
+					"histogram":    {Type: MetricHistogram, SampleRate: 0.5},
+					"distribution": {Type: MetricDistribution, SampleRate: 0.1},
+					"set":          {Type: MetricSet},
+				},
+				TagCols: []string{},
+			},
+			out: []mockStatsdMetric{
+				{method: "gauge", name: "test3.gauge", value: float64(1), tags: []string{}, rate: 1},
+				{method: "count", name: "test3.count", value: int64(2), tags: []string{}, rate: 1},
+				{method: "histogram", name: "test3.histogram", value: float64(0.3), tags: []string{}, rate: 0.5},
+				{method: "distribution", name: "test3.distribution", value: float64(0.4), tags: []string{}, rate: 0.1},
+				{method: "set", name: "test3.set", value: "5", tags: []string{}, rate: 1},
+			},
+		},
 	}
 
 	for _, tc := range cases {
 		t.Run(tc.rule.Name, func(t *testing.T) {
 			c := newMockStatsdClient()
 			d := newMockDogstatsd(c)
-			if err := d.Put(tc.qr, tc.rule); err != nil {
+			if err := d.Put(context.Background(), tc.qr, tc.rule); err != nil {
 				t.Errorf("Dogstatsd.Put(%+v, %+v) retruns unexpected err = %+v", tc.qr, tc.rule, err)
 			}
 
@@ -119,6 +212,28 @@ func TestDogstatsdPut(t *testing.T) {
 
 }
 
+func TestDogstatsdPutCountNotIntegral(t *testing.T) {
+	rule := Rule{
+		Name:       "test1",
+		ValueCols:  []string{"count"},
+		ValueTypes: map[string]ValueType{"count": {Type: MetricCount}},
+	}
+
+	for _, value := range []string{"2.5", "NaN", "+Inf"} {
+		t.Run(value, func(t *testing.T) {
+			c := newMockStatsdClient()
+			d := newMockDogstatsd(c)
+			qr := QueryResult{Records: []Record{{"count": {String: value}}}}
+			if err := d.Put(context.Background(), qr, rule); err == nil {
+				t.Errorf("Dogstatsd.Put(%s) returns no error, want an error", value)
+			}
+			if len(c.metrics) != 0 {
+				t.Errorf("Dogstatsd.Put(%s) sends %+v, want none", value, c.metrics)
+			}
+		})
+	}
+}
+
 func TestDogstatsdEvent(t *testing.T) {
 	cases := []struct {
 		in  *Event
@@ -160,7 +275,7 @@ func TestDogstatsdEvent(t *testing.T) {
 		t.Run(tc.in.Title, func(t *testing.T) {
 			c := newMockStatsdClient()
 			d := newMockDogstatsd(c)
-			if err := d.Event(tc.in); err != nil {
+			if err := d.Event(context.Background(), tc.in); err != nil {
 				t.Errorf("Dogstatsd.Event(%+v) retruns unexpected err = %+v", tc.in, err)
 			}
 
@@ -184,9 +299,132 @@ func TestDogstatsdEventUnknownLevel(t *testing.T) {
 		t.Run(tc.in.Title, func(t *testing.T) {
 			c := newMockStatsdClient()
 			d := newMockDogstatsd(c)
-			if err := d.Event(tc.in); err == nil {
+			if err := d.Event(context.Background(), tc.in); err == nil {
 				t.Errorf("Dogstatsd.Event(%+v) retruns expected unknown level error, but err == nil", tc.in)
 			}
 		})
 	}
 }
+
+func TestDogstatsdPutMetrics(t *testing.T) {
+	tags := []string{"rule:test", "data_source:default"}
+	metrics := []metric{
+		{name: "cyqldog.check.rows", value: 3, tags: tags, kind: MetricGauge},
+		{name: "cyqldog.check.errors", value: 1, tags: tags, kind: MetricCount},
+	}
+	want := []mockStatsdMetric{
+		{method: "gauge", name: "cyqldog.check.rows", value: float64(3), tags: tags, rate: 1},
+		{method: "count", name: "cyqldog.check.errors", value: int64(1), tags: tags, rate: 1},
+	}
+
+	c := newMockStatsdClient()
+	d := &Dogstatsd{client: c}
+	if err := d.putMetrics(context.Background(), metrics); err != nil {
+		t.Fatalf("Dogstatsd.putMetrics(%+v) returns unexpected err = %+v", metrics, err)
+	}
+	if !reflect.DeepEqual(c.metrics, want) {
+		t.Errorf("Dogstatsd.putMetrics(%+v)\n got = %+v,\nwant = %+v", metrics, c.metrics, want)
+	}
+}
+
+func TestBuildMetricsForRecordNull(t *testing.T) {
+	record := Record{
+		"tag1": {Null: true},
+		"val1": {Null: true},
+		"val2": {String: "2"},
+	}
+
+	cases := []struct {
+		name string
+		rule Rule
+		out  []metric
+		ok   bool
+	}{
+		{
+			name: "fail",
+			rule: Rule{Name: "fail", ValueCols: []string{"val1", "val2"}, TagCols: []string{"tag1"}},
+			ok:   false,
+		},
+		{
+			name: "skip",
+			rule: Rule{
+				Name:      "skip",
+				ValueCols: []string{"val1", "val2"},
+				TagCols:   []string{"tag1"},
+				NullValue: NullValuePolicy{Policy: NullValueSkip},
+			},
+			out: []metric{
+				{name: "skip.val2", value: 2, tags: []string{"tag1:null"}},
+			},
+			ok: true,
+		},
+		{
+			name: "default",
+			rule: Rule{
+				Name:      "default",
+				ValueCols: []string{"val1", "val2"},
+				TagCols:   []string{"tag1"},
+				NullValue: NullValuePolicy{Policy: NullValueDefault, Default: -1},
+				NullTag:   "none",
+			},
+			out: []metric{
+				{name: "default.val1", value: -1, tags: []string{"tag1:none"}},
+				{name: "default.val2", value: 2, tags: []string{"tag1:none"}},
+			},
+			ok: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := buildMetricsForRecord(record, tc.rule)
+
+			if !tc.ok {
+				if err == nil {
+					t.Errorf("expected buildMetricsForRecord(%+v, %+v) returns error, but err == nil", record, tc.rule)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("buildMetricsForRecord(%+v, %+v) returns unexpected err = %+v", record, tc.rule, err)
+			}
+			if !reflect.DeepEqual(got, tc.out) {
+				t.Errorf("buildMetricsForRecord(%+v, %+v)\n got = %+v,\nwant = %+v", record, tc.rule, got, tc.out)
+			}
+		})
+	}
+}
+
+func TestBuildMetricsForRecordTypes(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 500000000, time.UTC)
+	record := Record{
+		"created_at":   {String: ts.Format(time.RFC3339), Time: ts},
+		"datetime":     {String: "2024-01-02 03:04:05"},
+		"active":       {String: "1"},
+		"decimal":      {String: "12345.678"},
+		"created_date": {String: ts.Format(time.RFC3339), Time: ts},
+	}
+	rule := Rule{
+		Name:       "types",
+		ValueCols:  []string{"created_at", "datetime", "active", "decimal"},
+		TagCols:    []string{"created_date"},
+		TimeFormat: "2006-01-02",
+	}
+
+	got, err := buildMetricsForRecord(record, rule)
+	if err != nil {
+		t.Fatalf("buildMetricsForRecord(%+v, %+v) returns unexpected err = %+v", record, rule, err)
+	}
+
+	tags := []string{"created_date:2024-01-02"}
+	want := []metric{
+		{name: "types.created_at", value: 1704164645.5, tags: tags},
+		{name: "types.datetime", value: 1704164645, tags: tags},
+		{name: "types.active", value: 1, tags: tags},
+		{name: "types.decimal", value: 12345.678, tags: tags},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildMetricsForRecord(%+v, %+v)\n got = %+v,\nwant = %+v", record, rule, got, want)
+	}
+}
@@ -0,0 +1,127 @@
+package cyqldog
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrorEventsConfig is a configuration of the error events of failed checks.
+type ErrorEventsConfig struct {
+	// RepeatInterval is an interval to send a summary event while a rule keeps failing.
+	// If zero, repeated failures are suppressed until the rule recovers.
+	RepeatInterval time.Duration `yaml:"repeat_interval"`
+	// Retries is the number of retries of events which failed to be sent.
+	Retries int `yaml:"retries"`
+	// Backoff is a wait before the first retry, which doubles on each retry. (default: 1s)
+	Backoff time.Duration `yaml:"backoff"`
+	// Fallback is a name of the notifier to send the events which failed to be sent.
+	Fallback string `yaml:"fallback"`
+	// DeadLetter is a path of the file to append the events which could not be sent at all.
+	// If empty, they are written to the log.
+	DeadLetter string `yaml:"dead_letter"`
+}
+
+// failureState is the state of a failing rule.
+type failureState struct {
+	// since is when the rule started failing.
+	since time.Time
+	// failures is the number of consecutive failed checks.
+	failures int
+	// reported is when the last error event was sent.
+	reported time.Time
+	// suppressed is the number of failed checks without events since then.
+	suppressed int
+}
+
+// failureTracker keeps the error state of each rule to de-duplicate the error events.
+type failureTracker struct {
+	config ErrorEventsConfig
+
+	mu sync.Mutex
+	// states is a map of the names of the failing rules to their states.
+	states map[string]*failureState
+}
+
+// newFailureTracker returns an instance of failureTracker.
+func newFailureTracker(c ErrorEventsConfig) *failureTracker {
+	return &failureTracker{
+		config: c,
+		states: make(map[string]*failureState),
+	}
+}
+
+// failed records a failed check of the rule.
+// It returns true if this is the first failure, whose errors should be sent as they are.
+// While the rule keeps failing, it returns a summary event once per repeat interval,
+// otherwise nil to suppress the errors.
+func (t *failureTracker) failed(rule Rule, errs []error, now time.Time) (bool, *Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.states[rule.Name]
+	if !ok {
+		t.states[rule.Name] = &failureState{since: now, failures: 1, reported: now}
+		return true, nil
+	}
+
+	s.failures++
+	if t.config.RepeatInterval <= 0 || now.Sub(s.reported) < t.config.RepeatInterval {
+		s.suppressed++
+		return false, nil
+	}
+
+	event := newFailingEvent(rule, s, errs)
+	s.reported = now
+	s.suppressed = 0
+	return false, event
+}
+
+// succeeded records a successful check of the rule.
+// It returns a recovery event if the rule was failing, otherwise nil.
+func (t *failureTracker) succeeded(rule Rule, now time.Time) *Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.states[rule.Name]
+	if !ok {
+		return nil
+	}
+	delete(t.states, rule.Name)
+
+	return &Event{
+		Title: fmt.Sprintf("cyqldog: [recovered] rule %s", rule.Name),
+		Text:  fmt.Sprintf("rule %s recovered after %d failed checks in %s", rule.Name, s.failures, now.Sub(s.since).Truncate(time.Second)),
+		Level: "success",
+		Tags:  append([]string{"cyqldog"}, ruleTags(rule)...),
+	}
+}
+
+// remove forgets the state of the rule.
+func (t *failureTracker) remove(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.states, name)
+}
+
+// newFailingEvent returns a summary event of the rule which keeps failing.
+func newFailingEvent(rule Rule, s *failureState, errs []error) *Event {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, fmt.Sprintf("%+v", err))
+	}
+
+	return &Event{
+		Title: fmt.Sprintf("cyqldog: rule still failing: %s", rule.Name),
+		Text: fmt.Sprintf("%d failed checks since %s, %d error events suppressed\n\nlast errors:\n%s",
+			s.failures, s.since.Format(time.RFC3339), s.suppressed, strings.Join(msgs, "\n")),
+		Level: "error",
+		Tags:  append([]string{"cyqldog"}, ruleTags(rule)...),
+	}
+}
+
+// ruleTags returns the tags to identify the rule in events.
+func ruleTags(rule Rule) []string {
+	return []string{"rule:" + rule.Name, "data_source:" + rule.dataSourceName()}
+}
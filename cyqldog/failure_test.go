@@ -0,0 +1,86 @@
+package cyqldog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+func TestFailureTracker(t *testing.T) {
+	rule := Rule{Name: "test", DataSource: "replica"}
+	errs := []error{xerrors.New("connection refused")}
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name     string
+		interval time.Duration
+		// reports are whether each failure is sent as it is, summarized or suppressed.
+		reports []string
+	}{
+		{
+			name:     "suppress",
+			interval: 0,
+			reports:  []string{"first", "suppressed", "suppressed", "suppressed", "suppressed"},
+		},
+		{
+			name:     "summarize",
+			interval: 2 * time.Minute,
+			reports:  []string{"first", "suppressed", "summary", "suppressed", "summary"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker := newFailureTracker(ErrorEventsConfig{RepeatInterval: tc.interval})
+
+			if event := tracker.succeeded(rule, start); event != nil {
+				t.Errorf("failureTracker.succeeded() returns event = %+v before failures, want nil", event)
+			}
+
+			// Fail every minute.
+			reports := []string{}
+			now := start
+			for range tc.reports {
+				first, summary := tracker.failed(rule, errs, now)
+				switch {
+				case first:
+					reports = append(reports, "first")
+				case summary != nil:
+					reports = append(reports, "summary")
+					if summary.Level != "error" || !strings.Contains(summary.Text, "connection refused") {
+						t.Errorf("failureTracker.failed() returns summary = %+v, want the last error", summary)
+					}
+				default:
+					reports = append(reports, "suppressed")
+				}
+				now = now.Add(time.Minute)
+			}
+			if !reflect.DeepEqual(reports, tc.reports) {
+				t.Errorf("failureTracker.failed() reports\n got = %q,\nwant = %q", reports, tc.reports)
+			}
+
+			event := tracker.succeeded(rule, now)
+			if event == nil {
+				t.Fatalf("failureTracker.succeeded() returns nil after failures, want a recovery event")
+			}
+			if event.Level != "success" {
+				t.Errorf("failureTracker.succeeded() returns level = %s, want = success", event.Level)
+			}
+			want := []string{"cyqldog", "rule:test", "data_source:replica"}
+			if !reflect.DeepEqual(event.Tags, want) {
+				t.Errorf("failureTracker.succeeded() returns tags = %v, want = %v", event.Tags, want)
+			}
+
+			// Recovered rules start over.
+			if event := tracker.succeeded(rule, now); event != nil {
+				t.Errorf("failureTracker.succeeded() returns event = %+v twice, want nil", event)
+			}
+			if first, _ := tracker.failed(rule, errs, now); !first {
+				t.Errorf("failureTracker.failed() after recovery returns first = false, want = true")
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package cyqldog
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// pinger is implemented by data sources which can verify their connections.
+// Data sources which do not implement it are not checked in the background.
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// serviceCheckNotifier is implemented by notifiers which can send service checks.
+type serviceCheckNotifier interface {
+	serviceCheck(ctx context.Context, name string, ok bool, tags []string, message string) error
+}
+
+// dataSourceDownError represents that the query was skipped because the data source is down.
+type dataSourceDownError struct {
+	name string
+}
+
+// Error implements the error interface.
+func (e *dataSourceDownError) Error() string {
+	return fmt.Sprintf("data source is down: %s", e.name)
+}
+
+// healthChecker is a DataSource which pings the underlying one in the background.
+// While the data source is down, queries fail immediately without connecting,
+// so that the outage is notified once by the healthChecker instead of by every rule.
+type healthChecker struct {
+	name     string
+	ds       DataSource
+	pinger   pinger
+	interval time.Duration
+
+	notifiers Notifiers
+	delivery  *eventDelivery
+	internal  InternalMetricsConfig
+
+	// up is false after a ping fails until a ping succeeds.
+	up atomic.Bool
+}
+
+// newHealthChecker returns an instance of healthChecker.
+// It returns false if the data source cannot be pinged.
+func newHealthChecker(name string, ds DataSource, interval time.Duration, notifiers Notifiers, delivery *eventDelivery, internal InternalMetricsConfig) (*healthChecker, bool) {
+	p, ok := ds.(pinger)
+	if !ok {
+		return nil, false
+	}
+
+	h := &healthChecker{
+		name:      name,
+		ds:        ds,
+		pinger:    p,
+		interval:  interval,
+		notifiers: notifiers,
+		delivery:  delivery,
+		internal:  internal,
+	}
+	// The data source has been connected.
+	h.up.Store(true)
+	return h, true
+}
+
+// Get queries the data source unless it is down.
+func (h *healthChecker) Get(ctx context.Context, rule Rule) (QueryResult, error) {
+	if !h.up.Load() {
+		return QueryResult{}, &dataSourceDownError{name: h.name}
+	}
+	return h.ds.Get(ctx, rule)
+}
+
+// Close closes the data source.
+func (h *healthChecker) Close() error {
+	return h.ds.Close()
+}
+
+// run pings the data source at the interval until the ctx is done.
+func (h *healthChecker) run(ctx context.Context) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			h.check(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// check pings the data source and reports the result.
+// An event is sent only when the state changes.
+func (h *healthChecker) check(ctx context.Context) {
+	pctx, cancel := context.WithTimeout(ctx, h.interval)
+	err := h.pinger.Ping(pctx)
+	cancel()
+	if ctx.Err() != nil {
+		// Stopped while pinging.
+		return
+	}
+
+	up := err == nil
+	tags := []string{"data_source:" + h.name}
+
+	if prev := h.up.Swap(up); prev != up {
+		event := &Event{
+			Title: fmt.Sprintf("cyqldog: [recovered] data source %s", h.name),
+			Text:  fmt.Sprintf("data source %s is up", h.name),
+			Level: "success",
+			Tags:  append([]string{"cyqldog"}, tags...),
+		}
+		if !up {
+			log.Printf("health: data source is down: %s: %+v", h.name, err)
+			event.Title = fmt.Sprintf("cyqldog: data source down: %s", h.name)
+			event.Text = fmt.Sprintf("%+v", err)
+			event.Level = "error"
+		} else {
+			log.Printf("health: data source is up: %s", h.name)
+		}
+		for _, name := range h.notifiers.names() {
+			h.delivery.send(ctx, name, event)
+		}
+	}
+
+	h.report(ctx, up, tags, err)
+}
+
+// report sends the gauge and the service check of the state to the notifiers.
+func (h *healthChecker) report(ctx context.Context, up bool, tags []string, err error) {
+	if h.internal.Disabled {
+		return
+	}
+
+	name := h.internal.namespace() + ".data_source.up"
+	value := 0.0
+	message := ""
+	if up {
+		value = 1
+	} else {
+		message = err.Error()
+	}
+
+	for _, n := range h.notifiers.names() {
+		if mn, ok := h.notifiers[n].(metricsNotifier); ok {
+			if err := mn.putMetrics(ctx, []metric{{name: name, value: value, tags: tags, kind: MetricGauge}}); err != nil {
+				log.Printf("health: failed to send metrics: notifier = %s: %+v", n, err)
+			}
+		}
+		if sn, ok := h.notifiers[n].(serviceCheckNotifier); ok {
+			if err := sn.serviceCheck(ctx, name, up, tags, message); err != nil {
+				log.Printf("health: failed to send service check: notifier = %s: %+v", n, err)
+			}
+		}
+	}
+}
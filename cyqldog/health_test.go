@@ -0,0 +1,118 @@
+package cyqldog
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"golang.org/x/xerrors"
+)
+
+// mockPingDataSource is a mock of DataSource which can be pinged.
+type mockPingDataSource struct {
+	mockDataSource
+
+	mu      sync.Mutex
+	pingErr error
+}
+
+// Ping implements an interface of pinger for testing.
+func (d *mockPingDataSource) Ping(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.pingErr
+}
+
+func TestHealthCheckerCheck(t *testing.T) {
+	ds := &mockPingDataSource{}
+	n := &mockNotifier{}
+	c := newMockStatsdClient()
+	notifiers := Notifiers{"mock": n, "dogstatsd": &Dogstatsd{client: c}}
+	h, ok := newHealthChecker("replica", ds, time.Second, notifiers, newEventDelivery(ErrorEventsConfig{}, notifiers, InternalMetricsConfig{}), InternalMetricsConfig{})
+	if !ok {
+		t.Fatalf("newHealthChecker() returns false for a data source implementing Ping")
+	}
+
+	rule := Rule{Name: "test", DataSource: "replica", Notifier: "mock"}
+	// levels are the levels of the events sent after each check.
+	levels := func() []string {
+		ls := []string{}
+		for _, e := range n.events {
+			ls = append(ls, e.Level)
+		}
+		return ls
+	}
+
+	h.check(context.Background())
+	if got := levels(); len(got) != 0 {
+		t.Errorf("healthChecker.check() sends events = %v while up, want none", got)
+	}
+
+	ds.pingErr = xerrors.New("connection refused")
+	h.check(context.Background())
+	h.check(context.Background())
+	if got := levels(); !reflect.DeepEqual(got, []string{"error"}) {
+		t.Errorf("healthChecker.check() sends events = %v after down, want = [error]", got)
+	}
+	_, err := h.Get(context.Background(), rule)
+	var de *dataSourceDownError
+	if !xerrors.As(err, &de) {
+		t.Errorf("healthChecker.Get() returns err = %+v while down, want dataSourceDownError", err)
+	}
+	if got := errorClass(newCheckError(errorClassQuery, err)); got != errorClassUnavailable {
+		t.Errorf("newCheckError() returns class = %s while down, want = %s", got, errorClassUnavailable)
+	}
+
+	ds.pingErr = nil
+	h.check(context.Background())
+	if got := levels(); !reflect.DeepEqual(got, []string{"error", "success"}) {
+		t.Errorf("healthChecker.check() sends events = %v after up, want = [error success]", got)
+	}
+	if _, err := h.Get(context.Background(), rule); err != nil {
+		t.Errorf("healthChecker.Get() returns unexpected err = %+v after up", err)
+	}
+
+	values := []float64{}
+	for _, m := range n.metrics {
+		if m.name != "cyqldog.data_source.up" || !reflect.DeepEqual(m.tags, []string{"data_source:replica"}) {
+			t.Errorf("healthChecker.check() sends metric = %+v", m)
+		}
+		values = append(values, m.value)
+	}
+	if want := []float64{1, 0, 0, 1}; !reflect.DeepEqual(values, want) {
+		t.Errorf("healthChecker.check() sends values = %v, want = %v", values, want)
+	}
+
+	statuses := []statsd.ServiceCheckStatus{}
+	for _, sc := range c.checks {
+		statuses = append(statuses, sc.Status)
+	}
+	if want := []statsd.ServiceCheckStatus{statsd.Ok, statsd.Critical, statsd.Critical, statsd.Ok}; !reflect.DeepEqual(statuses, want) {
+		t.Errorf("healthChecker.check() sends service checks = %v, want = %v", statuses, want)
+	}
+}
+
+func TestCheckerProcessDataSourceDown(t *testing.T) {
+	ds := &mockPingDataSource{pingErr: xerrors.New("connection refused")}
+	n := &mockNotifier{}
+	notifiers := Notifiers{"mock": n}
+	delivery := newEventDelivery(ErrorEventsConfig{}, notifiers, InternalMetricsConfig{})
+	h, _ := newHealthChecker("default", ds, time.Second, notifiers, delivery, InternalMetricsConfig{Disabled: true})
+	h.check(context.Background())
+
+	status := newStatusTracker()
+	c := newChecker(DataSources{"default": h}, notifiers, newAlertTracker(), newFailureTracker(ErrorEventsConfig{}), delivery, status, InternalMetricsConfig{Disabled: true})
+	rule := Rule{Name: "test", Notifier: "mock", ValueCols: []string{"count"}}
+	c.process(context.Background(), newTask(rule))
+
+	// Only the event of the data source is sent.
+	if len(n.events) != 1 {
+		t.Errorf("Checker.process() sends events = %+v while the data source is down, want only the health check", n.events)
+	}
+	if s := status.list(); len(s) != 1 || len(s[0].LastError) == 0 {
+		t.Errorf("Checker.process() records status = %+v, want the error", s)
+	}
+}
@@ -0,0 +1,147 @@
+package cyqldog
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// InternalMetricsConfig is a configuration of the metrics about cyqldog itself.
+type InternalMetricsConfig struct {
+	// Namespace to prepend to the internal metrics. (default: cyqldog)
+	Namespace string `yaml:"namespace"`
+	// Disabled stops sending the internal metrics.
+	Disabled bool `yaml:"disabled"`
+}
+
+// defaultInternalNamespace is a default namespace of the internal metrics.
+const defaultInternalNamespace = "cyqldog"
+
+// namespace returns the namespace with the default applied.
+func (c InternalMetricsConfig) namespace() string {
+	if len(c.Namespace) == 0 {
+		return defaultInternalNamespace
+	}
+	return c.Namespace
+}
+
+// metricsNotifier is implemented by notifiers which can send the internal metrics.
+// Notifiers which do not implement it simply do not receive the internal metrics.
+type metricsNotifier interface {
+	putMetrics(ctx context.Context, metrics []metric) error
+}
+
+// poolStatser is implemented by data sources which have a connection pool.
+type poolStatser interface {
+	poolStats() sql.DBStats
+}
+
+// Classes of errors occurred in checks.
+const (
+	errorClassConfig      = "config"
+	errorClassQuery       = "query"
+	errorClassUnavailable = "unavailable"
+	errorClassTimeout     = "timeout"
+	errorClassConvert     = "convert"
+	errorClassNotifier    = "notifier"
+	errorClassAlert       = "alert"
+)
+
+// checkError is an error of a check with the class of the error.
+type checkError struct {
+	class string
+	err   error
+}
+
+// Error implements the error interface.
+func (e *checkError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *checkError) Unwrap() error {
+	return e.err
+}
+
+// newCheckError wraps the error with the class.
+// Timeouts are always classified as timeout,
+// and queries skipped while the data source is down are classified as unavailable.
+func newCheckError(class string, err error) error {
+	var te *timeoutError
+	var de *dataSourceDownError
+	switch {
+	case xerrors.As(err, &te):
+		class = errorClassTimeout
+	case xerrors.As(err, &de):
+		class = errorClassUnavailable
+	}
+	return &checkError{class: class, err: err}
+}
+
+// errorClass returns the class of the error.
+func errorClass(err error) string {
+	var ce *checkError
+	if xerrors.As(err, &ce) {
+		return ce.class
+	}
+	return errorClassQuery
+}
+
+// checkStats are measurements of a check.
+type checkStats struct {
+	// queueWait is how long the task sat in the queue before a checker took it.
+	queueWait time.Duration
+	// queryDuration is how long the query took.
+	queryDuration time.Duration
+	// rows is the number of rows returned by the query.
+	rows int
+	// metrics is the number of metrics sent.
+	metrics int
+}
+
+// buildInternalMetrics returns the internal metrics of a check.
+// The metrics are tagged by the rule name and the data source name.
+// Each error is counted separately, tagged by the notifier if it failed.
+func buildInternalMetrics(c InternalMetricsConfig, rule Rule, stats checkStats, errs []error) []metric {
+	prefix := c.namespace() + ".check."
+	tags := []string{"rule:" + rule.Name, "data_source:" + rule.dataSourceName()}
+
+	metrics := []metric{
+		{name: prefix + "queue_wait", value: stats.queueWait.Seconds(), tags: tags, kind: MetricGauge},
+		{name: prefix + "query_duration", value: stats.queryDuration.Seconds(), tags: tags, kind: MetricGauge},
+		{name: prefix + "rows", value: float64(stats.rows), tags: tags, kind: MetricGauge},
+		{name: prefix + "metrics", value: float64(stats.metrics), tags: tags, kind: MetricGauge},
+	}
+
+	for _, err := range errs {
+		errTags := append(append([]string{}, tags...), "error_class:"+errorClass(err))
+		var ne *notifierError
+		if xerrors.As(err, &ne) {
+			errTags = append(errTags, "notifier:"+ne.notifier)
+		}
+		metrics = append(metrics, metric{name: prefix + "errors", value: 1, tags: errTags, kind: MetricCount})
+	}
+
+	return metrics
+}
+
+// buildPoolMetrics returns the internal metrics of the connection pool of the data source.
+// The counts of waits and closed connections are totals since the data source was connected.
+func buildPoolMetrics(c InternalMetricsConfig, name string, stats sql.DBStats) []metric {
+	prefix := c.namespace() + ".data_source."
+	tags := []string{"data_source:" + name}
+
+	return []metric{
+		{name: prefix + "max_open_connections", value: float64(stats.MaxOpenConnections), tags: tags, kind: MetricGauge},
+		{name: prefix + "open_connections", value: float64(stats.OpenConnections), tags: tags, kind: MetricGauge},
+		{name: prefix + "in_use", value: float64(stats.InUse), tags: tags, kind: MetricGauge},
+		{name: prefix + "idle", value: float64(stats.Idle), tags: tags, kind: MetricGauge},
+		{name: prefix + "wait_count", value: float64(stats.WaitCount), tags: tags, kind: MetricGauge},
+		{name: prefix + "wait_duration", value: stats.WaitDuration.Seconds(), tags: tags, kind: MetricGauge},
+		{name: prefix + "max_idle_closed", value: float64(stats.MaxIdleClosed), tags: tags, kind: MetricGauge},
+		{name: prefix + "max_idle_time_closed", value: float64(stats.MaxIdleTimeClosed), tags: tags, kind: MetricGauge},
+		{name: prefix + "max_lifetime_closed", value: float64(stats.MaxLifetimeClosed), tags: tags, kind: MetricGauge},
+	}
+}
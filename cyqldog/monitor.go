@@ -1,72 +1,575 @@
 package cyqldog
 
 import (
+	"context"
+	"io"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+
+	"golang.org/x/xerrors"
 )
 
 // Monitor represents a main process of monitoring.
 type Monitor struct {
 	configPath string
+
+	// config is the configuration currently running.
+	config *Config
+	// dsc are the configurations of the data sources currently running.
+	dsc DataSourcesConfig
+	// notifiers are shared by all checkers.
+	notifiers Notifiers
+	// alerts are shared by all checkers.
+	alerts *alertTracker
+	// failures are shared by all checkers.
+	failures *failureTracker
+	// delivery sends the error events of all checkers and the monitor.
+	delivery *eventDelivery
+	// pools are the checkers of each data source.
+	pools map[string]*checkerPool
+	// schedulers are the running schedulers of each rule name.
+	schedulers map[string]*Scheduler
+	// status is the latest state of each rule shared by the checkers and the schedulers.
+	status *statusTracker
+
+	// admin is the running admin server, or nil if disabled.
+	admin *http.Server
+	// ready becomes true after the data sources and the notifiers are set up.
+	ready atomic.Bool
+
+	// openDataSources connects to the data sources until the ctx is done.
+	// We make a layer of abstraction for testing.
+	openDataSources func(ctx context.Context, c DataSourcesConfig) (DataSources, error)
+	// newNotifiers initializes the notifiers.
+	// It is replaced in the dry-run mode.
+	newNotifiers func(c NotifiersConfig) (Notifiers, error)
+	// loadConfig returns the configuration to run.
+	// It is replaced when the components are prebuilt.
+	loadConfig func() (*Config, error)
+}
+
+// checkerPool is a group of checkers sharing the task queue of a data source.
+type checkerPool struct {
+	ds DataSource
+	q  chan task
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
 }
 
 // NewMonitor returns an instance of Monitor.
 func NewMonitor(configPath string) *Monitor {
 	return &Monitor{
-		configPath: configPath,
+		configPath:      configPath,
+		pools:           make(map[string]*checkerPool),
+		schedulers:      make(map[string]*Scheduler),
+		alerts:          newAlertTracker(),
+		status:          newStatusTracker(),
+		openDataSources: newDataSources,
+		newNotifiers:    newNotifiers,
+		loadConfig: func() (*Config, error) {
+			log.Printf("monitor: load config file: %s", configPath)
+			return newConfig(configPath)
+		},
+	}
+}
+
+// NewMonitorWithComponents returns an instance of Monitor which runs the rules of the config
+// with the prebuilt data sources and notifiers instead of loading a configuration file.
+// The rules select them by the names in the maps.
+// The data sources and the notifiers in the config are ignored,
+// except max_concurrency of the data sources of the same names.
+// The data sources and the notifiers are closed when the Monitor stops.
+func NewMonitorWithComponents(config Config, dss DataSources, notifiers Notifiers) *Monitor {
+	m := NewMonitor("")
+	m.loadConfig = func() (*Config, error) {
+		return newComponentsConfig(config, dss, notifiers)
+	}
+	m.openDataSources = func(ctx context.Context, c DataSourcesConfig) (DataSources, error) {
+		opened := make(DataSources, len(c))
+		for name := range c {
+			opened[name] = dss[name]
+		}
+		return opened, nil
+	}
+	m.newNotifiers = func(c NotifiersConfig) (Notifiers, error) {
+		return notifiers, nil
+	}
+	return m
+}
+
+// DryRun replaces every notifier with a Printer writing to w in the format,
+// so that the metrics and events are printed instead of sent.
+// The printed records are labeled with the name of the notifier,
+// and the metric names are prefixed with the namespace of the notifier.
+// This function returns a error if the format is unknown.
+func (m *Monitor) DryRun(w io.Writer, format string) error {
+	p, err := newPrinter(w, format)
+	if err != nil {
+		return err
+	}
+
+	m.newNotifiers = func(c NotifiersConfig) (Notifiers, error) {
+		notifiers := make(Notifiers)
+		for _, name := range c.names() {
+			notifiers[name] = p.forNotifier(name, c[name].namespace())
+		}
+		return notifiers, nil
 	}
+	return nil
 }
 
 // Run is a main routine of cyqldog.
 func (m *Monitor) Run() error {
-	// Load the configuration file.
-	log.Printf("monitor: load config file: %s", m.configPath)
-	config, err := newConfig(m.configPath)
+	if err := m.start(); err != nil {
+		return err
+	}
+	defer m.closeNotifiers()
+	// The queued events are sent before the notifiers are closed.
+	defer m.delivery.stop()
+	defer m.closeDataSources()
+	defer m.stopAdmin()
+
+	// Reloading runs in the background, so that it does not block stopping while reconnecting.
+	// It is cancelled and waited for before stopping.
+	ctx, cancel := context.WithCancel(context.Background())
+	var reloading sync.WaitGroup
+	var running atomic.Bool
+	defer reloading.Wait()
+	defer cancel()
+
+	// Trap signals from OS for normal termination and reloading.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	// The main goroutine keep on waiting here.
+	for {
+		s := <-sig
+		if s == syscall.SIGHUP {
+			if !running.CompareAndSwap(false, true) {
+				log.Printf("monitor: reload is already running")
+				continue
+			}
+			reloading.Add(1)
+			go func() {
+				defer reloading.Done()
+				defer running.Store(false)
+				m.reload(ctx)
+			}()
+			continue
+		}
+
+		log.Println("monitor: stopping")
+		return nil
+	}
+}
+
+// RunOnce checks each rule exactly once and returns without scheduling.
+// If names are given, only the rules with the names are checked.
+// This function returns a error if any rule fails.
+func (m *Monitor) RunOnce(names []string) error {
+	config, err := m.loadConfig()
 	if err != nil {
 		return err
 	}
 
-	// Connect to the database.
-	ds, err := newDB(config.DB)
+	rules, err := selectRules(config.Rules, names)
 	if err != nil {
 		return err
 	}
-	defer ds.Close()
 
-	// Initialize notifiers.
-	notifiers, err := newNotifiers(config.Notifiers)
+	if err := m.setup(config); err != nil {
+		return err
+	}
+	defer m.closeNotifiers()
+	defer m.stopCheckerPools()
+
+	return m.checkOnce(rules)
+}
+
+// selectRules returns the rules with the names in the order of the names.
+// If no names are given, all the rules are returned.
+func selectRules(rules []Rule, names []string) ([]Rule, error) {
+	if len(names) == 0 {
+		return rules, nil
+	}
+
+	byName := make(map[string]Rule, len(rules))
+	for _, r := range rules {
+		byName[r.Name] = r
+	}
+
+	selected := make([]Rule, 0, len(names))
+	for _, name := range names {
+		r, ok := byName[name]
+		if !ok {
+			return nil, xerrors.Errorf("unknown rule: %s", name)
+		}
+		selected = append(selected, r)
+	}
+	return selected, nil
+}
+
+// checkOnce puts a task of each rule in the queue of its data source and waits for all of them.
+// The checks go through the same checkers as the scheduled ones,
+// so the max concurrency of each data source is respected.
+func (m *Monitor) checkOnce(rules []Rule) error {
+	for _, rule := range rules {
+		if _, ok := m.pools[rule.dataSourceName()]; !ok {
+			return xerrors.Errorf("unknown data source: rule = %s, data_source = %s", rule.Name, rule.dataSourceName())
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, rule := range rules {
+		q := m.pools[rule.dataSourceName()].q
+		t := newTask(rule)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q <- t
+			<-t.done
+		}()
+	}
+	wg.Wait()
+
+	// The checkers record the result of each rule.
+	failed := []string{}
+	for _, s := range m.status.list() {
+		if len(s.LastError) > 0 {
+			failed = append(failed, s.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return xerrors.Errorf("%d of %d rules failed: %s", len(failed), len(rules), strings.Join(failed, ", "))
+	}
+
+	log.Printf("monitor: %d rules checked", len(rules))
+	return nil
+}
+
+// start loads the configuration file and starts monitoring.
+func (m *Monitor) start() error {
+	config, err := m.loadConfig()
 	if err != nil {
 		return err
 	}
 
-	// Make a task queue for monitoring job.
-	q := make(chan Rule)
+	// Serve the admin endpoints first, so that liveness can be checked while connecting.
+	if config.Admin.enabled() {
+		if err := m.startAdmin(config.Admin); err != nil {
+			return err
+		}
+	}
+
+	if err := m.setup(config); err != nil {
+		m.stopAdmin()
+		return err
+	}
 
 	// Make a scheduler for each rule.
-	for i, rule := range config.Rules {
-		scheduler := newScheduler(i, rule)
-		go scheduler.run(q)
+	if err := m.startSchedulers(config.Rules, nil); err != nil {
+		m.closeDataSources()
+		m.closeNotifiers()
+		m.stopAdmin()
+		return err
 	}
 
-	// Make a monitoring worker.
-	// In order to limit the number of DB connection to 1 for monitoring,
-	// only one worker should run.
-	c := newChecker(ds, notifiers)
-	go c.run(q)
+	// Send the events in the background not to block the checkers while retrying.
+	// RunOnce does not start it, so that all the events are sent before returning.
+	m.delivery.start()
 
-	// Trap signals from OS for normal termination.
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	// The data sources are pinged on connecting, so we are ready to check.
+	m.ready.Store(true)
 
-	// The main goroutine keep on waiting here.
-loop:
-	for {
-		<-stop
-		log.Println("monitor: stopping")
-		break loop
+	return nil
+}
+
+// setup connects to the data sources, initializes the notifiers,
+// and starts the checkers of each data source.
+func (m *Monitor) setup(config *Config) error {
+	// Connect to the databases.
+	dsc, err := config.dataSourcesConfig()
+	if err != nil {
+		return err
+	}
+	dss, err := m.openDataSources(context.Background(), dsc)
+	if err != nil {
+		return err
 	}
 
+	// Initialize notifiers.
+	notifiers, err := m.newNotifiers(config.Notifiers)
+	if err != nil {
+		dss.Close()
+		return err
+	}
+	m.notifiers = notifiers
+	m.failures = newFailureTracker(config.ErrorEvents)
+	m.delivery = newEventDelivery(config.ErrorEvents, notifiers, config.InternalMetrics)
+	m.config = config
+
+	// Make a task queue and monitoring workers for each data source.
+	for name, ds := range dss {
+		m.pools[name] = m.startCheckerPool(name, dsc[name], ds)
+	}
+	m.dsc = dsc
+
+	return nil
+}
+
+// reload re-reads the configuration file and applies the differences.
+// If the new configuration is invalid, an error event is sent and the current one keeps running.
+// Connecting to the data sources is given up when the ctx is done.
+func (m *Monitor) reload(ctx context.Context) {
+	if len(m.configPath) == 0 {
+		log.Printf("monitor: no config file to reload")
+		return
+	}
+
+	log.Printf("monitor: reload config file: %s", m.configPath)
+	if err := m.applyConfig(ctx); err != nil {
+		if ctx.Err() != nil {
+			log.Printf("monitor: reload cancelled: %+v", err)
+			return
+		}
+		log.Printf("monitor: failed to reload: %+v", err)
+		m.notifyError(xerrors.Errorf("failed to reload config, keep running the previous one: %w", err))
+		return
+	}
+	log.Printf("monitor: reloaded")
+}
+
+// applyConfig loads the configuration file and applies the differences.
+// Data sources are reconnected only when their configurations are changed,
+// and only the schedulers of the changed rules are restarted.
+func (m *Monitor) applyConfig(ctx context.Context) error {
+	config, err := m.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	// Notifiers keep their connections and listeners,
+	// so changing them requires a restart.
+	if !reflect.DeepEqual(config.Notifiers, m.config.Notifiers) {
+		return xerrors.New("notifiers cannot be changed by reloading, restart is required")
+	}
+	// The running checkers keep the configuration of the internal metrics.
+	if !reflect.DeepEqual(config.InternalMetrics, m.config.InternalMetrics) {
+		return xerrors.New("internal_metrics cannot be changed by reloading, restart is required")
+	}
+	// The running checkers keep the error states of the rules.
+	if !reflect.DeepEqual(config.ErrorEvents, m.config.ErrorEvents) {
+		return xerrors.New("error_events cannot be changed by reloading, restart is required")
+	}
+	// The admin server keeps its listener.
+	if !reflect.DeepEqual(config.Admin, m.config.Admin) {
+		return xerrors.New("admin cannot be changed by reloading, restart is required")
+	}
+
+	dsc, err := config.dataSourcesConfig()
+	if err != nil {
+		return err
+	}
+
+	// Connect to the new and changed data sources before stopping anything,
+	// so that the current ones keep running on failure.
+	changed := DataSourcesConfig{}
+	for name, c := range dsc {
+		if old, ok := m.dsc[name]; !ok || !reflect.DeepEqual(c, old) {
+			changed[name] = c
+		}
+	}
+	dss, err := m.openDataSources(ctx, changed)
+	if err != nil {
+		return err
+	}
+
+	// Data sources to be stopped are the changed and removed ones.
+	stopped := make(map[string]bool)
+	for name := range m.dsc {
+		if _, ok := dsc[name]; !ok {
+			stopped[name] = true
+		}
+		if _, ok := changed[name]; ok {
+			stopped[name] = true
+		}
+	}
+
+	// Stop the schedulers of the removed and changed rules,
+	// and the ones of the rules which query the stopped data sources.
+	newRules := make(map[string]Rule, len(config.Rules))
+	for _, r := range config.Rules {
+		newRules[r.Name] = r
+	}
+	for name, s := range m.schedulers {
+		r, ok := newRules[name]
+		if ok && reflect.DeepEqual(r, s.rule) && !stopped[s.rule.dataSourceName()] {
+			continue
+		}
+		log.Printf("monitor: stop scheduler: %s", name)
+		s.stop()
+		delete(m.schedulers, name)
+		if !ok {
+			m.status.remove(name)
+			m.failures.remove(name)
+		}
+		m.forgetRule(s.rule, r, ok)
+	}
+
+	// Replace the data sources.
+	for name := range stopped {
+		log.Printf("monitor: disconnect data source: %s", name)
+		m.pools[name].stop()
+		delete(m.pools, name)
+	}
+	for name, ds := range dss {
+		log.Printf("monitor: connect data source: %s", name)
+		m.pools[name] = m.startCheckerPool(name, changed[name], ds)
+	}
+	m.dsc = dsc
+
+	// Start the schedulers which are not running.
+	if err := m.startSchedulers(config.Rules, m.schedulers); err != nil {
+		return err
+	}
+	m.config = config
+
+	return nil
+}
+
+// startSchedulers starts a scheduler for each rule except the running ones.
+func (m *Monitor) startSchedulers(rules []Rule, running map[string]*Scheduler) error {
+	for i, rule := range rules {
+		if _, ok := running[rule.Name]; ok {
+			continue
+		}
+
+		pool, ok := m.pools[rule.dataSourceName()]
+		if !ok {
+			return xerrors.Errorf("unknown data source: rule = %s, data_source = %s", rule.Name, rule.dataSourceName())
+		}
+
+		scheduler, err := newScheduler(i, rule, m.status)
+		if err != nil {
+			return err
+		}
+		scheduler.start(pool.q)
+		m.schedulers[rule.Name] = scheduler
+	}
 	return nil
 }
+
+// forgetRule clears the states of the stopped rule which the new rule does not take over.
+// If the rule is removed, its alerts recover and all its notifiers forget its metrics.
+// Otherwise only the notifiers which the new rule does not use forget them.
+func (m *Monitor) forgetRule(old Rule, rule Rule, kept bool) {
+	used := make(map[string]bool)
+	if kept {
+		for _, name := range rule.notifierNames() {
+			used[name] = true
+		}
+	} else {
+		for _, event := range m.alerts.remove(old) {
+			for _, name := range old.notifierNames() {
+				m.delivery.send(context.Background(), name, event)
+			}
+		}
+	}
+
+	for _, name := range old.notifierNames() {
+		if f, ok := m.notifiers[name].(ruleForgetter); ok && !used[name] {
+			f.forget(old.Name)
+		}
+	}
+}
+
+// notifyError sends an error event to all the notifiers.
+func (m *Monitor) notifyError(err error) {
+	event := newErrorEvent(err)
+	for _, name := range m.notifiers.names() {
+		m.delivery.send(context.Background(), name, event)
+	}
+}
+
+// closeDataSources closes all the data sources.
+func (m *Monitor) closeDataSources() {
+	for name, p := range m.pools {
+		if err := p.ds.Close(); err != nil {
+			log.Printf("monitor: failed to close data source: %s: %+v", name, err)
+		}
+	}
+}
+
+// closeNotifiers closes the notifiers which hold resources such as listeners.
+func (m *Monitor) closeNotifiers() {
+	for _, name := range m.notifiers.names() {
+		c, ok := m.notifiers[name].(io.Closer)
+		if !ok {
+			continue
+		}
+		if err := c.Close(); err != nil {
+			log.Printf("monitor: failed to close notifier: %s: %+v", name, err)
+		}
+	}
+}
+
+// stopCheckerPools stops the checkers of all the data sources and closes the data sources.
+func (m *Monitor) stopCheckerPools() {
+	for name, p := range m.pools {
+		p.stop()
+		delete(m.pools, name)
+	}
+}
+
+// startCheckerPool makes a task queue for the data source,
+// and starts as many checkers as the max concurrency of the data source.
+// In order to limit the number of DB connections for monitoring,
+// the checkers of a data source share the queue.
+func (m *Monitor) startCheckerPool(name string, c DataSourceConfig, ds DataSource) *checkerPool {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &checkerPool{
+		ds:     ds,
+		q:      make(chan task),
+		cancel: cancel,
+	}
+
+	// The checkers query through the health checker if the data source is checked in the background.
+	if c.HealthCheckInterval > 0 {
+		if h, ok := newHealthChecker(name, ds, c.HealthCheckInterval, m.notifiers, m.delivery, m.config.InternalMetrics); ok {
+			ds = h
+			p.wg.Add(1)
+			go func() {
+				defer p.wg.Done()
+				h.run(ctx)
+			}()
+		}
+	}
+
+	for i := 0; i < c.maxConcurrency(); i++ {
+		checker := newChecker(DataSources{name: ds}, m.notifiers, m.alerts, m.failures, m.delivery, m.status, m.config.InternalMetrics)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			checker.run(ctx, p.q)
+		}()
+	}
+
+	return p
+}
+
+// stop stops the checkers after their running checks finish, and closes the data source.
+func (p *checkerPool) stop() {
+	p.cancel()
+	p.wg.Wait()
+	if err := p.ds.Close(); err != nil {
+		log.Printf("monitor: failed to close data source: %+v", err)
+	}
+}
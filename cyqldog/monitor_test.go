@@ -0,0 +1,446 @@
+package cyqldog
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// writeConfig writes the configuration file for testing.
+func writeConfig(t *testing.T, path string, body string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(body), 0600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+}
+
+// newMockMonitor returns a Monitor connecting to mock data sources.
+// The names of the data sources connected are recorded to opened.
+func newMockMonitor(configPath string, opened *[]string) *Monitor {
+	m := NewMonitor(configPath)
+	m.openDataSources = func(ctx context.Context, c DataSourcesConfig) (DataSources, error) {
+		dss := make(DataSources)
+		for name := range c {
+			*opened = append(*opened, name)
+			dss[name] = &mockDataSource{}
+		}
+		sort.Strings(*opened)
+		return dss, nil
+	}
+	return m
+}
+
+func TestMonitorReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cyqldog.yml")
+
+	writeConfig(t, path, `
+data_sources:
+  primary:
+    driver: postgres
+    options:
+      host: primary.db.example.com
+  replica:
+    driver: postgres
+    options:
+      host: replica1.db.example.com
+
+notifiers:
+  dogstatsd:
+    host: 127.0.0.1
+    port: 8125
+
+rules:
+  - name: unchanged
+    interval: 1h
+    query: "SELECT 1 AS count"
+    data_source: primary
+    notifier: dogstatsd
+    value_cols: [count]
+  - name: changed
+    interval: 1h
+    query: "SELECT 1 AS count"
+    data_source: primary
+    notifier: dogstatsd
+    value_cols: [count]
+  - name: removed
+    interval: 1h
+    query: "SELECT 1 AS count"
+    data_source: primary
+    notifier: dogstatsd
+    value_cols: [count]
+  - name: replica
+    interval: 1h
+    query: "SELECT 1 AS count"
+    data_source: replica
+    notifier: dogstatsd
+    value_cols: [count]
+`)
+
+	opened := []string{}
+	m := newMockMonitor(path, &opened)
+	if err := m.start(); err != nil {
+		t.Fatalf("Monitor.start() returns unexpected err = %+v", err)
+	}
+	defer m.closeDataSources()
+
+	if want := []string{"primary", "replica"}; !reflect.DeepEqual(opened, want) {
+		t.Errorf("Monitor.start() opens data sources = %v, want = %v", opened, want)
+	}
+
+	before := make(map[string]*Scheduler)
+	for name, s := range m.schedulers {
+		before[name] = s
+	}
+
+	// Change the rules and the replica data source.
+	writeConfig(t, path, `
+data_sources:
+  primary:
+    driver: postgres
+    options:
+      host: primary.db.example.com
+  replica:
+    driver: postgres
+    options:
+      host: replica2.db.example.com
+
+notifiers:
+  dogstatsd:
+    host: 127.0.0.1
+    port: 8125
+
+rules:
+  - name: unchanged
+    interval: 1h
+    query: "SELECT 1 AS count"
+    data_source: primary
+    notifier: dogstatsd
+    value_cols: [count]
+  - name: changed
+    interval: 2h
+    query: "SELECT 1 AS count"
+    data_source: primary
+    notifier: dogstatsd
+    value_cols: [count]
+  - name: added
+    interval: 1h
+    query: "SELECT 1 AS count"
+    data_source: primary
+    notifier: dogstatsd
+    value_cols: [count]
+  - name: replica
+    interval: 1h
+    query: "SELECT 1 AS count"
+    data_source: replica
+    notifier: dogstatsd
+    value_cols: [count]
+`)
+
+	opened = []string{}
+	if err := m.applyConfig(context.Background()); err != nil {
+		t.Fatalf("Monitor.applyConfig() returns unexpected err = %+v", err)
+	}
+
+	if want := []string{"replica"}; !reflect.DeepEqual(opened, want) {
+		t.Errorf("Monitor.applyConfig() reconnects data sources = %v, want = %v", opened, want)
+	}
+
+	names := []string{}
+	for name := range m.schedulers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if want := []string{"added", "changed", "replica", "unchanged"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Monitor.applyConfig() runs schedulers = %v, want = %v", names, want)
+	}
+
+	restarted := map[string]bool{"unchanged": false, "changed": true, "replica": true}
+	for name, want := range restarted {
+		if got := m.schedulers[name] != before[name]; got != want {
+			t.Errorf("Monitor.applyConfig() restarts scheduler %s = %v, want = %v", name, got, want)
+		}
+	}
+
+	// An invalid config is rejected, and the current one keeps running.
+	current := make(map[string]*Scheduler)
+	for name, s := range m.schedulers {
+		current[name] = s
+	}
+
+	writeConfig(t, path, `
+data_sources:
+  primary:
+    driver: postgres
+    options:
+      host: primary.db.example.com
+
+notifiers:
+  dogstatsd:
+    host: 127.0.0.1
+    port: 8125
+
+rules:
+  - name: invalid
+    interval: 0s
+    query: "SELECT 1 AS count"
+    data_source: primary
+    notifier: dogstatsd
+    value_cols: [count]
+`)
+
+	opened = []string{}
+	if err := m.applyConfig(context.Background()); err == nil {
+		t.Errorf("expected Monitor.applyConfig() with invalid config returns error, but err == nil")
+	}
+
+	if !reflect.DeepEqual(m.schedulers, current) {
+		t.Errorf("Monitor.applyConfig() with invalid config changes schedulers = %v, want = %v", m.schedulers, current)
+	}
+	if len(opened) != 0 {
+		t.Errorf("Monitor.applyConfig() with invalid config opens data sources = %v", opened)
+	}
+
+	// Reconnecting is given up when the monitor stops, without error events.
+	writeConfig(t, path, `
+data_sources:
+  primary:
+    driver: postgres
+    options:
+      host: primary.db.example.com
+  replica:
+    driver: postgres
+    options:
+      host: replica3.db.example.com
+
+notifiers:
+  dogstatsd:
+    host: 127.0.0.1
+    port: 8125
+
+rules:
+  - name: replica
+    interval: 1h
+    query: "SELECT 1 AS count"
+    data_source: replica
+    notifier: dogstatsd
+    value_cols: [count]
+`)
+
+	m.openDataSources = func(ctx context.Context, c DataSourcesConfig) (DataSources, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	n := &mockNotifier{}
+	m.notifiers = Notifiers{"mock": n}
+	m.delivery = newEventDelivery(ErrorEventsConfig{}, m.notifiers, InternalMetricsConfig{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	m.reload(ctx)
+
+	if !reflect.DeepEqual(m.schedulers, current) {
+		t.Errorf("Monitor.reload() cancelled changes schedulers = %v, want = %v", m.schedulers, current)
+	}
+	if len(n.events) != 0 {
+		t.Errorf("Monitor.reload() cancelled sends events = %+v, want none", n.events)
+	}
+}
+
+// forgettingNotifier is a mock of Notifier which records the forgotten rules.
+type forgettingNotifier struct {
+	mockNotifier
+	forgotten []string
+}
+
+// forget implements ruleForgetter for testing.
+func (n *forgettingNotifier) forget(name string) {
+	n.forgotten = append(n.forgotten, name)
+}
+
+func TestMonitorReloadForget(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cyqldog.yml")
+	config := `
+data_source:
+  driver: postgres
+
+notifiers:
+  dogstatsd:
+    host: 127.0.0.1
+    port: 8125
+  prometheus:
+    type: prometheus
+    listen_address: 127.0.0.1:0
+
+rules:
+  - name: kept
+    interval: 1h
+    query: "SELECT 1 AS count"
+    notifiers: [dogstatsd, prometheus]
+    value_cols: [count]
+`
+	writeConfig(t, path, config+`
+  - name: removed
+    interval: 1h
+    query: "SELECT 1 AS count"
+    notifier: prometheus
+    value_cols: [count]
+    alerts:
+      - value_col: count
+        critical: 0
+`)
+
+	opened := []string{}
+	m := newMockMonitor(path, &opened)
+	dogstatsd := &forgettingNotifier{}
+	prometheus := &forgettingNotifier{}
+	m.newNotifiers = func(c NotifiersConfig) (Notifiers, error) {
+		return Notifiers{"dogstatsd": dogstatsd, "prometheus": prometheus}, nil
+	}
+	if err := m.start(); err != nil {
+		t.Fatalf("Monitor.start() returns unexpected err = %+v", err)
+	}
+	defer m.closeDataSources()
+
+	// The removed rule is in the critical level.
+	m.alerts.evaluate(m.schedulers["removed"].rule, []metric{{name: "removed.count", value: 1}})
+
+	// Remove the rule, and stop using prometheus for the kept rule.
+	writeConfig(t, path, strings.Replace(config, "notifiers: [dogstatsd, prometheus]", "notifier: dogstatsd", 1))
+	if err := m.applyConfig(context.Background()); err != nil {
+		t.Fatalf("Monitor.applyConfig() returns unexpected err = %+v", err)
+	}
+	m.delivery.stop()
+
+	sort.Strings(prometheus.forgotten)
+	if want := []string{"kept", "removed"}; !reflect.DeepEqual(prometheus.forgotten, want) {
+		t.Errorf("Monitor.applyConfig() makes prometheus forget %v, want = %v", prometheus.forgotten, want)
+	}
+	if len(dogstatsd.forgotten) != 0 {
+		t.Errorf("Monitor.applyConfig() makes dogstatsd forget %v, want none", dogstatsd.forgotten)
+	}
+	if len(prometheus.events) != 1 || prometheus.events[0].Level != "success" {
+		t.Errorf("Monitor.applyConfig() sends events = %+v to prometheus, want a recovery event", prometheus.events)
+	}
+}
+
+func TestMonitorRunOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cyqldog.yml")
+
+	writeConfig(t, path, `
+data_sources:
+  primary:
+    driver: postgres
+    options:
+      host: primary.db.example.com
+  broken:
+    driver: postgres
+    options:
+      host: broken.db.example.com
+
+notifiers:
+  dogstatsd:
+    host: 127.0.0.1
+    port: 8125
+
+rules:
+  - name: ok
+    interval: 1h
+    query: "SELECT 1 AS count"
+    data_source: primary
+    notifier: dogstatsd
+    value_cols: [count]
+  - name: failed
+    interval: 1h
+    query: "SELECT 1 AS count"
+    data_source: broken
+    notifier: dogstatsd
+    value_cols: [count]
+`)
+
+	cases := []struct {
+		names   []string
+		checked []string
+		wantErr bool
+	}{
+		{names: nil, checked: []string{"failed", "ok"}, wantErr: true},
+		{names: []string{"ok"}, checked: []string{"ok"}, wantErr: false},
+		{names: []string{"failed"}, checked: []string{"failed"}, wantErr: true},
+		{names: []string{"unknown"}, checked: []string{}, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		m := NewMonitor(path)
+		m.openDataSources = func(ctx context.Context, c DataSourcesConfig) (DataSources, error) {
+			return DataSources{
+				"primary": &mockDataSource{},
+				"broken":  &mockDataSource{err: xerrors.New("connection refused")},
+			}, nil
+		}
+
+		err := m.RunOnce(tc.names)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("Monitor.RunOnce(%v) returns err = %v, wantErr = %t", tc.names, err, tc.wantErr)
+		}
+
+		checked := []string{}
+		for _, s := range m.status.list() {
+			checked = append(checked, s.Name)
+		}
+		if !reflect.DeepEqual(checked, tc.checked) {
+			t.Errorf("Monitor.RunOnce(%v) checks %v, want = %v", tc.names, checked, tc.checked)
+		}
+		if len(m.schedulers) > 0 {
+			t.Errorf("Monitor.RunOnce(%v) starts schedulers: %v", tc.names, m.schedulers)
+		}
+	}
+}
+
+// closingNotifier is a mock of Notifier which records whether it is closed.
+type closingNotifier struct {
+	mockNotifier
+	closed bool
+}
+
+// Close implements io.Closer for testing.
+func (n *closingNotifier) Close() error {
+	n.closed = true
+	return nil
+}
+
+func TestNewMonitorWithComponents(t *testing.T) {
+	n := &closingNotifier{}
+	config := Config{
+		Rules: []Rule{
+			{Name: "test1", Interval: time.Hour, Query: "SELECT 1 AS count", DataSource: "primary", Notifier: "mock", ValueCols: []string{"count"}},
+		},
+	}
+	dss := DataSources{
+		"primary": &mockDataSource{result: QueryResult{Records: []Record{{"count": {String: "1"}}}}},
+	}
+
+	m := NewMonitorWithComponents(config, dss, Notifiers{"mock": n})
+	if err := m.RunOnce(nil); err != nil {
+		t.Fatalf("Monitor.RunOnce() returns unexpected err = %+v", err)
+	}
+	if len(n.results) != 1 {
+		t.Errorf("Monitor.RunOnce() puts %d results, want = 1", len(n.results))
+	}
+	if !n.closed {
+		t.Errorf("Monitor.RunOnce() does not close the notifier")
+	}
+
+	// The rules are validated against the names of the components.
+	config.Rules[0].Notifier = "unknown"
+	m = NewMonitorWithComponents(config, dss, Notifiers{"mock": n})
+	var errs ValidationErrors
+	if err := m.RunOnce(nil); !xerrors.As(err, &errs) {
+		t.Errorf("Monitor.RunOnce() with unknown notifier returns err = %+v, want ValidationErrors", err)
+	}
+}
@@ -1,11 +1,25 @@
 package cyqldog
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"golang.org/x/xerrors"
+
+	yaml "gopkg.in/yaml.v2"
+)
 
 // Notifier is an interface which send metrics to.
 type Notifier interface {
-	Put(qr QueryResult, rule Rule) error
-	Event(e *Event) error
+	Put(ctx context.Context, qr QueryResult, rule Rule) error
+	Event(ctx context.Context, e *Event) error
+}
+
+// ruleForgetter is implemented by notifiers which keep the latest metrics of each rule.
+// The metrics of the rules removed by reloading are forgotten.
+type ruleForgetter interface {
+	forget(name string)
 }
 
 // An Event is an object that can be posted to the Notifier.
@@ -19,31 +33,195 @@ type Event struct {
 	Level string
 	// Tags for the event.
 	Tags []string
+	// Rule is the name of the rule which caused the event.
+	// It is empty if the event is not related to a rule.
+	Rule string
+	// Channel overrides the destination of the event for notifiers which support it.
+	// If empty, the default of the notifier is used.
+	Channel string
 }
 
 // Notifiers is a map of notifiers.
 type Notifiers map[string]Notifier
 
-// NotifiersConfig are configurations of notifiers.
-type NotifiersConfig struct {
+// Types of notifiers.
+const (
+	NotifierDogstatsd  = "dogstatsd"
+	NotifierPrometheus = "prometheus"
+	NotifierSlack      = "slack"
+	NotifierWebhook    = "webhook"
+)
+
+// notifierTypes are the supported types of notifiers.
+var notifierTypes = []string{NotifierDogstatsd, NotifierPrometheus, NotifierSlack, NotifierWebhook}
+
+// NotifiersConfig are configurations of notifiers by name.
+// Rules select notifiers by the name.
+type NotifiersConfig map[string]NotifierConfig
+
+// NotifierConfig is a configuration of a notifier.
+// Only the configuration of the type is set.
+// The configuration of a type registered by RegisterNotifier is decoded by its factory.
+type NotifierConfig struct {
+	// Type is a type of the notifier.
+	// If empty, the name of the notifier is used for backward compatibility.
+	Type string
 	// Dogstatsd is a configuration of the dogstatsd to connect.
-	Dogstatsd DogstatsdConfig `yaml:"dogstatsd"`
+	Dogstatsd DogstatsdConfig
+	// Prometheus is a configuration of the prometheus exporter.
+	Prometheus PrometheusConfig
+	// Slack is a configuration of the slack incoming webhook to post events to.
+	Slack SlackConfig
+	// Webhook is a configuration of the webhook to post events to.
+	Webhook WebhookConfig
+
+	// raw is the yaml of the notifier passed to the factory of a registered type.
+	raw []byte
+}
+
+// UnmarshalYAML parses the notifiers.
+// Each notifier is a map of the type and the configuration of the type, such as:
+//
+//	analytics:
+//	  type: dogstatsd
+//	  host: 127.0.0.1
+//	  port: 8125
+func (c *NotifiersConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	raw := make(map[string]interface{})
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	notifiers := make(NotifiersConfig, len(raw))
+	for name, v := range raw {
+		// Parse the type first, and then the configuration of the type.
+		buf, err := yaml.Marshal(v)
+		if err != nil {
+			return xerrors.Errorf("failed to parse notifier: %s: %w", name, err)
+		}
+		var t struct {
+			Type string `yaml:"type"`
+		}
+		if err := yaml.Unmarshal(buf, &t); err != nil {
+			return xerrors.Errorf("failed to parse notifier: %s: %w", name, err)
+		}
+
+		nc := NotifierConfig{Type: t.Type}
+		if len(nc.Type) == 0 {
+			nc.Type = name
+		}
+		var target interface{}
+		switch nc.Type {
+		case NotifierDogstatsd:
+			target = &nc.Dogstatsd
+		case NotifierPrometheus:
+			target = &nc.Prometheus
+		case NotifierSlack:
+			target = &nc.Slack
+		case NotifierWebhook:
+			target = &nc.Webhook
+		default:
+			// Registered types are decoded by their factories, and unknown types are reported by the validation.
+			nc.raw = buf
+		}
+		if target != nil {
+			if err := yaml.Unmarshal(buf, target); err != nil {
+				return xerrors.Errorf("failed to parse notifier: %s: %w", name, err)
+			}
+		}
+		notifiers[name] = nc
+	}
+
+	*c = notifiers
+	return nil
+}
+
+// names returns the sorted names of the notifiers.
+func (c NotifiersConfig) names() []string {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// namespace returns the namespace prepended to the metric names by the notifier.
+func (c NotifierConfig) namespace() string {
+	switch c.Type {
+	case NotifierDogstatsd:
+		return c.Dogstatsd.Namespace
+	case NotifierPrometheus:
+		return c.Prometheus.Namespace
+	}
+	return ""
+}
+
+// names returns the sorted names of the notifiers.
+func (n Notifiers) names() []string {
+	names := make([]string, 0, len(n))
+	for name := range n {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // newNotifiers returns an instance of Notifiers.
 func newNotifiers(c NotifiersConfig) (Notifiers, error) {
 	notifiers := make(Notifiers)
 
-	dogstatsd, err := newDogstatsd(c.Dogstatsd)
-	if err != nil {
-		return notifiers, err
-	}
+	for _, name := range c.names() {
+		notifier, err := newNotifier(c[name])
+		if err != nil {
+			return notifiers, xerrors.Errorf("failed to initialize notifier: %s: %w", name, err)
+		}
 
-	notifiers["dogstatsd"] = dogstatsd
+		notifiers[name] = notifier
+	}
 
 	return notifiers, nil
 }
 
+// newNotifier returns an instance of Notifier of the type.
+func newNotifier(c NotifierConfig) (Notifier, error) {
+	switch c.Type {
+	case NotifierDogstatsd:
+		return newDogstatsd(c.Dogstatsd)
+	case NotifierPrometheus:
+		return newPrometheus(c.Prometheus)
+	case NotifierSlack:
+		return newSlack(c.Slack)
+	case NotifierWebhook:
+		return newWebhook(c.Webhook)
+	}
+
+	factory, ok := lookupNotifier(c.Type)
+	if !ok {
+		return nil, xerrors.Errorf("unknown notifier type: %s", c.Type)
+	}
+	return factory(func(v interface{}) error {
+		return yaml.Unmarshal(c.raw, v)
+	})
+}
+
+// notifierType returns the type of the notifier.
+// Notifiers of library users are identified by their Go types.
+func notifierType(n Notifier) string {
+	switch n.(type) {
+	case *Dogstatsd:
+		return NotifierDogstatsd
+	case *Prometheus:
+		return NotifierPrometheus
+	case *Slack:
+		return NotifierSlack
+	case *Webhook:
+		return NotifierWebhook
+	}
+	return fmt.Sprintf("%T", n)
+}
+
+// newErrorEvent returns an error event.
 func newErrorEvent(err error) *Event {
 	return &Event{
 		Title: fmt.Sprintf("cyqldog: %s", err),
@@ -52,3 +230,13 @@ func newErrorEvent(err error) *Event {
 		Tags:  []string{"cyqldog"},
 	}
 }
+
+// newTimeoutEvent returns an error event for the rule which timed out.
+func newTimeoutEvent(err *timeoutError) *Event {
+	return &Event{
+		Title: fmt.Sprintf("cyqldog: rule timed out: %s", err.rule.Name),
+		Text:  fmt.Sprintf("%+v", err),
+		Level: "error",
+		Tags:  []string{"cyqldog", "timeout"},
+	}
+}
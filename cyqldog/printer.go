@@ -0,0 +1,181 @@
+package cyqldog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"golang.org/x/xerrors"
+)
+
+// Output formats of the Printer.
+const (
+	// PrintTable renders metrics and events as an aligned table.
+	PrintTable = "table"
+	// PrintJSON renders each metric and event as a line of JSON.
+	PrintJSON = "json"
+)
+
+// Printer is a notifier which writes metrics and events instead of sending them.
+// It is used in the dry-run mode to check which metrics the rules produce.
+type Printer struct {
+	format string
+	// notifier is the name of the notifier replaced with the Printer.
+	notifier string
+	// namespace is prepended to the metric names as the notifier does.
+	namespace string
+
+	// mu serializes writes from the concurrent checkers.
+	// It is shared by the printers of all the notifiers writing to w.
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+// newPrinter returns an instance of Printer.
+// This function returns a error if the format is unknown.
+func newPrinter(w io.Writer, format string) (*Printer, error) {
+	switch format {
+	case PrintTable, PrintJSON:
+	default:
+		return nil, xerrors.Errorf("unknown print format: %s", format)
+	}
+
+	return &Printer{format: format, mu: &sync.Mutex{}, w: w}, nil
+}
+
+// forNotifier returns a Printer labeling the records with the name of the notifier.
+// It writes to the same writer as p.
+func (p *Printer) forNotifier(name, namespace string) *Printer {
+	return &Printer{
+		format:    p.format,
+		notifier:  name,
+		namespace: namespace,
+		mu:        p.mu,
+		w:         p.w,
+	}
+}
+
+// printerRecord is a line of the JSON format.
+type printerRecord struct {
+	// Type is metric or event.
+	Type string `json:"type"`
+	// Notifier is the name of the notifier which the record is sent to.
+	Notifier string `json:"notifier,omitempty"`
+	// Rule is the name of the rule of the metric.
+	Rule string `json:"rule,omitempty"`
+	// Kind is gauge or count.
+	Kind  string   `json:"kind,omitempty"`
+	Name  string   `json:"name,omitempty"`
+	Value *float64 `json:"value,omitempty"`
+	// Title, Text and Level are the fields of the event.
+	Title string   `json:"title,omitempty"`
+	Text  string   `json:"text,omitempty"`
+	Level string   `json:"level,omitempty"`
+	Tags  []string `json:"tags"`
+}
+
+// Put writes the metrics built from the query result.
+func (p *Printer) Put(ctx context.Context, qr QueryResult, rule Rule) error {
+	metrics, err := buildMetricsForQueryResult(qr, rule)
+	if err != nil {
+		return err
+	}
+
+	return p.printMetrics(rule.Name, metrics)
+}
+
+// putMetrics writes the internal metrics.
+func (p *Printer) putMetrics(ctx context.Context, metrics []metric) error {
+	return p.printMetrics("", metrics)
+}
+
+// Event writes the event.
+func (p *Printer) Event(ctx context.Context, e *Event) error {
+	level := e.Level
+	if len(level) == 0 {
+		level = "info"
+	}
+
+	return p.print([]printerRecord{{
+		Type:     "event",
+		Notifier: p.notifier,
+		Title:    e.Title,
+		Text:     e.Text,
+		Level:    level,
+		Tags:     e.Tags,
+	}})
+}
+
+// printMetrics writes the metrics of the rule.
+func (p *Printer) printMetrics(rule string, metrics []metric) error {
+	records := make([]printerRecord, 0, len(metrics))
+	for _, m := range metrics {
+		kind := m.kind
+		if len(kind) == 0 {
+			kind = MetricGauge
+		}
+		name := m.name
+		if len(p.namespace) > 0 {
+			name = p.namespace + "." + name
+		}
+		value := m.value
+		records = append(records, printerRecord{
+			Type:     "metric",
+			Notifier: p.notifier,
+			Rule:     rule,
+			Kind:     kind,
+			Name:     name,
+			Value:    &value,
+			Tags:     m.tags,
+		})
+	}
+
+	return p.print(records)
+}
+
+// print writes the records in the format of the Printer.
+// The records are written at once so as not to be interleaved with other checks.
+func (p *Printer) print(records []printerRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.format == PrintJSON {
+		enc := json.NewEncoder(p.w)
+		for _, r := range records {
+			if r.Tags == nil {
+				r.Tags = []string{}
+			}
+			if err := enc.Encode(r); err != nil {
+				return xerrors.Errorf("failed to print %s: %w", r.Type, err)
+			}
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 8, 2, ' ', 0)
+	if records[0].Type == "event" {
+		fmt.Fprintln(tw, "NOTIFIER\tEVENT\tLEVEL\tTAGS")
+		for _, r := range records {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Notifier, r.Title, r.Level, strings.Join(r.Tags, ","))
+		}
+	} else {
+		fmt.Fprintln(tw, "NOTIFIER\tMETRIC\tKIND\tVALUE\tTAGS")
+		for _, r := range records {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Notifier, r.Name, r.Kind, strconv.FormatFloat(*r.Value, 'g', -1, 64), strings.Join(r.Tags, ","))
+		}
+	}
+	fmt.Fprintln(tw)
+	if err := tw.Flush(); err != nil {
+		return xerrors.Errorf("failed to print %s: %w", records[0].Type, err)
+	}
+	return nil
+}
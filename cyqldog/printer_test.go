@@ -0,0 +1,106 @@
+package cyqldog
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestPrinter(t *testing.T) {
+	rule := Rule{
+		Name:      "test2",
+		TagCols:   []string{"tag1"},
+		ValueCols: []string{"val1", "val2"},
+	}
+	qr := QueryResult{
+		Records: []Record{
+			{"tag1": Value{String: "foo"}, "val1": Value{String: "10"}, "val2": Value{String: "2.5"}},
+		},
+	}
+	event := &Event{Title: "cyqldog: failed", Text: "detail", Level: "error", Tags: []string{"cyqldog"}}
+
+	cases := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: PrintTable,
+			want: `NOTIFIER   METRIC          KIND   VALUE  TAGS
+analytics  app.test2.val1  gauge  10     tag1:foo
+analytics  app.test2.val2  gauge  2.5    tag1:foo
+
+NOTIFIER   EVENT            LEVEL  TAGS
+analytics  cyqldog: failed  error  cyqldog
+
+`,
+		},
+		{
+			format: PrintJSON,
+			want: `{"type":"metric","notifier":"analytics","rule":"test2","kind":"gauge","name":"app.test2.val1","value":10,"tags":["tag1:foo"]}
+{"type":"metric","notifier":"analytics","rule":"test2","kind":"gauge","name":"app.test2.val2","value":2.5,"tags":["tag1:foo"]}
+{"type":"event","notifier":"analytics","title":"cyqldog: failed","text":"detail","level":"error","tags":["cyqldog"]}
+`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			printer, err := newPrinter(&buf, tc.format)
+			if err != nil {
+				t.Fatalf("newPrinter(%s) returns unexpected err = %+v", tc.format, err)
+			}
+			p := printer.forNotifier("analytics", "app")
+
+			if err := p.Put(context.Background(), qr, rule); err != nil {
+				t.Fatalf("Printer.Put() returns unexpected err = %+v", err)
+			}
+			if err := p.Event(context.Background(), event); err != nil {
+				t.Fatalf("Printer.Event() returns unexpected err = %+v", err)
+			}
+
+			if got := buf.String(); got != tc.want {
+				t.Errorf("Printer prints:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := newPrinter(&bytes.Buffer{}, "yaml"); err == nil {
+		t.Errorf("newPrinter(yaml) returns no error, want an error")
+	}
+}
+
+func TestMonitorDryRun(t *testing.T) {
+	var buf bytes.Buffer
+	m := &Monitor{}
+	if err := m.DryRun(&buf, PrintTable); err != nil {
+		t.Fatalf("Monitor.DryRun() returns unexpected err = %+v", err)
+	}
+
+	notifiers, err := m.newNotifiers(NotifiersConfig{
+		"analytics": NotifierConfig{Type: NotifierDogstatsd, Dogstatsd: DogstatsdConfig{Namespace: "app"}},
+		"alerts":    NotifierConfig{Type: NotifierSlack},
+	})
+	if err != nil {
+		t.Fatalf("newNotifiers() returns unexpected err = %+v", err)
+	}
+
+	rule := Rule{Name: "test2", ValueCols: []string{"val1"}}
+	qr := QueryResult{Records: []Record{{"val1": Value{String: "10"}}}}
+	for _, name := range notifiers.names() {
+		if err := notifiers[name].Put(context.Background(), qr, rule); err != nil {
+			t.Fatalf("Printer.Put() returns unexpected err = %+v", err)
+		}
+	}
+
+	want := `NOTIFIER  METRIC      KIND   VALUE  TAGS
+alerts    test2.val1  gauge  10     
+
+NOTIFIER   METRIC          KIND   VALUE  TAGS
+analytics  app.test2.val1  gauge  10     
+
+`
+	if got := buf.String(); got != want {
+		t.Errorf("Monitor.DryRun() prints:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,368 @@
+package cyqldog
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+// PrometheusConfig is a configuration of the prometheus exporter.
+type PrometheusConfig struct {
+	// ListenAddress is an address to serve metrics. (e.g. ":9187")
+	ListenAddress string `yaml:"listen_address"`
+	// Path is a path to serve metrics. (default: /metrics)
+	Path string `yaml:"path"`
+	// Namespace to prepend to all metric names.
+	Namespace string `yaml:"namespace"`
+}
+
+// prometheusSample represents a latest value of a series.
+type prometheusSample struct {
+	name   string
+	labels []prometheusLabel
+	value  float64
+}
+
+// prometheusLabel is a pair of label name and value.
+type prometheusLabel struct {
+	name  string
+	value string
+}
+
+// Prometheus is a notifier which exposes metrics in the prometheus text exposition format.
+type Prometheus struct {
+	namespace string
+
+	mu sync.RWMutex
+	// series is a map of rule names to the latest samples of the rule.
+	series map[string][]prometheusSample
+	// events is a map of event levels to the number of events.
+	events map[string]float64
+	// internal is a map of series to the internal metrics of cyqldog.
+	internal map[string]prometheusSample
+	// counters is a set of metric names of the counter type.
+	counters map[string]bool
+
+	server *http.Server
+}
+
+// newPrometheus returns an instance of Notifier interface.
+// This function returns a error if it cannot listen on the address.
+func newPrometheus(c PrometheusConfig) (Notifier, error) {
+	p := newPrometheusExporter(c.Namespace)
+
+	path := c.Path
+	if len(path) == 0 {
+		path = "/metrics"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, p)
+
+	l, err := net.Listen("tcp", c.ListenAddress)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to listen prometheus exporter: listen_address=%s: %w", c.ListenAddress, err)
+	}
+
+	p.server = &http.Server{Handler: mux}
+	go func() {
+		log.Printf("prometheus: serve: %s%s", l.Addr(), path)
+		if err := p.server.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Printf("prometheus: failed to serve: %+v", err)
+		}
+	}()
+
+	return p, nil
+}
+
+// newPrometheusExporter returns an instance of Prometheus without a listener.
+func newPrometheusExporter(namespace string) *Prometheus {
+	return &Prometheus{
+		namespace: namespace,
+		series:    make(map[string][]prometheusSample),
+		events:    make(map[string]float64),
+		internal:  make(map[string]prometheusSample),
+		counters:  make(map[string]bool),
+	}
+}
+
+// Put replaces the latest samples of the rule with the query result.
+// Series which disappear from the query result are removed.
+// If rows produce the same series, the last row wins,
+// because prometheus rejects a scrape with duplicate series.
+func (p *Prometheus) Put(ctx context.Context, qr QueryResult, rule Rule) error {
+	samples := []prometheusSample{}
+	// index is a map of series to their positions in samples.
+	index := make(map[string]int)
+
+	for _, record := range qr.Records {
+		ms, err := buildMetricsForRecord(record, rule)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range ms {
+			s := prometheusSample{
+				name:   p.metricName(m.name),
+				labels: buildPrometheusLabels(m.tags, rule.TagCols),
+				value:  m.value,
+			}
+			key := formatPrometheusSample(prometheusSample{name: s.name, labels: s.labels})
+			if i, ok := index[key]; ok {
+				samples[i] = s
+				continue
+			}
+			index[key] = len(samples)
+			samples = append(samples, s)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.series[rule.Name] = samples
+
+	return nil
+}
+
+// Event counts the event by level.
+// Prometheus has no concept of events, so the event itself is only logged.
+func (p *Prometheus) Event(ctx context.Context, e *Event) error {
+	level := e.Level
+	if len(level) == 0 {
+		level = "info"
+	}
+	log.Printf("prometheus: event: [%s] %s", level, e.Title)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events[level]++
+
+	return nil
+}
+
+// putMetrics records the internal metrics.
+// Gauges are replaced with the latest values and counts are added up to counters.
+func (p *Prometheus) putMetrics(ctx context.Context, metrics []metric) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, m := range metrics {
+		name := p.metricName(m.name)
+		if m.kind == MetricCount {
+			name += "_total"
+			p.counters[name] = true
+		}
+
+		s := prometheusSample{
+			name:   name,
+			labels: buildPrometheusLabelsFromTags(m.tags),
+			value:  m.value,
+		}
+		key := formatPrometheusSample(prometheusSample{name: s.name, labels: s.labels})
+		if m.kind == MetricCount {
+			s.value += p.internal[key].value
+		}
+		p.internal[key] = s
+	}
+
+	return nil
+}
+
+// forget removes the samples of the rule, including its internal metrics.
+func (p *Prometheus) forget(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.series, name)
+	for key, s := range p.internal {
+		for _, l := range s.labels {
+			if l.name == "rule" && l.value == name {
+				delete(p.internal, key)
+				break
+			}
+		}
+	}
+}
+
+// Close stops serving metrics.
+func (p *Prometheus) Close() error {
+	if p.server == nil {
+		return nil
+	}
+	return p.server.Close()
+}
+
+// ServeHTTP writes all the latest samples in the text exposition format.
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprint(w, p.render())
+}
+
+// render returns all the latest samples in the text exposition format.
+func (p *Prometheus) render() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	// Group samples by metric name, because a TYPE line must appear only once per metric.
+	byName := make(map[string][]string)
+	for _, samples := range p.series {
+		for _, s := range samples {
+			byName[s.name] = append(byName[s.name], formatPrometheusSample(s))
+		}
+	}
+
+	for _, s := range p.internal {
+		byName[s.name] = append(byName[s.name], formatPrometheusSample(s))
+	}
+
+	eventsName := p.metricName("cyqldog.events_total")
+	levels := make([]string, 0, len(p.events))
+	for level := range p.events {
+		levels = append(levels, level)
+	}
+	sort.Strings(levels)
+	for _, level := range levels {
+		s := prometheusSample{
+			name:   eventsName,
+			labels: []prometheusLabel{{name: "level", value: level}},
+			value:  p.events[level],
+		}
+		byName[s.name] = append(byName[s.name], formatPrometheusSample(s))
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		typ := "gauge"
+		if name == eventsName || p.counters[name] {
+			typ = "counter"
+		}
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, typ)
+
+		lines := byName[name]
+		sort.Strings(lines)
+		for _, line := range lines {
+			b.WriteString(line)
+			b.WriteString("\n")
+		}
+	}
+
+	return b.String()
+}
+
+// metricName returns a valid prometheus metric name prefixed with the namespace.
+func (p *Prometheus) metricName(name string) string {
+	if len(p.namespace) > 0 {
+		name = p.namespace + "." + name
+	}
+	return sanitizePrometheusName(name, true)
+}
+
+// buildPrometheusLabels converts tags built by buildTags to labels.
+// The tags are formatted as column name:value in the order of tagCols.
+func buildPrometheusLabels(tags []string, tagCols []string) []prometheusLabel {
+	labels := make([]prometheusLabel, 0, len(tags))
+	for i, tc := range tagCols {
+		labels = append(labels, prometheusLabel{
+			name:  sanitizePrometheusName(tc, false),
+			value: strings.TrimPrefix(tags[i], tc+":"),
+		})
+	}
+	return labels
+}
+
+// buildPrometheusLabelsFromTags converts tags formatted as name:value to labels.
+// Tags without a value are ignored.
+func buildPrometheusLabelsFromTags(tags []string) []prometheusLabel {
+	labels := make([]prometheusLabel, 0, len(tags))
+	for _, tag := range tags {
+		i := strings.Index(tag, ":")
+		if i < 0 {
+			continue
+		}
+		labels = append(labels, prometheusLabel{
+			name:  sanitizePrometheusName(tag[:i], false),
+			value: tag[i+1:],
+		})
+	}
+	return labels
+}
+
+// formatPrometheusSample returns a line of the text exposition format.
+func formatPrometheusSample(s prometheusSample) string {
+	var b strings.Builder
+	b.WriteString(s.name)
+
+	if len(s.labels) > 0 {
+		b.WriteString("{")
+		for i, l := range s.labels {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString(l.name)
+			b.WriteString(`="`)
+			b.WriteString(escapePrometheusLabelValue(l.value))
+			b.WriteString(`"`)
+		}
+		b.WriteString("}")
+	}
+
+	b.WriteString(" ")
+	b.WriteString(formatPrometheusValue(s.value))
+	return b.String()
+}
+
+// formatPrometheusValue formats a float value as prometheus expects.
+func formatPrometheusValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// escapePrometheusLabelValue escapes backslashes, double quotes and line feeds.
+func escapePrometheusLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// sanitizePrometheusName replaces invalid characters in metric and label names with underscores.
+// Colons are only allowed in metric names.
+func sanitizePrometheusName(s string, metric bool) string {
+	b := []byte(s)
+	for i, c := range b {
+		valid := c == '_' ||
+			(c >= 'a' && c <= 'z') ||
+			(c >= 'A' && c <= 'Z') ||
+			(c >= '0' && c <= '9') ||
+			(c == ':' && metric)
+		if !valid {
+			b[i] = '_'
+		}
+	}
+
+	// Names must not start with a digit.
+	if len(b) > 0 && b[0] >= '0' && b[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
@@ -0,0 +1,223 @@
+package cyqldog
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPrometheusPut(t *testing.T) {
+	cases := []struct {
+		name      string
+		namespace string
+		puts      []QueryResult
+		rule      Rule
+		out       string
+	}{
+		{
+			name:      "single",
+			namespace: "cyqldog",
+			puts: []QueryResult{
+				{Records: []Record{{"count": {String: "3"}}}},
+			},
+			rule: Rule{
+				Name:      "test1",
+				Interval:  (5 * time.Second),
+				Query:     "SELECT COUNT(*) AS count FROM table1",
+				Notifier:  "prometheus",
+				ValueCols: []string{"count"},
+				TagCols:   []string{},
+			},
+			out: "# TYPE cyqldog_test1_count gauge\n" +
+				"cyqldog_test1_count 3\n",
+		},
+		{
+			name:      "labels",
+			namespace: "",
+			puts: []QueryResult{
+				{
+					Records: []Record{
+						{"tag1": {String: "hoge1"}, "val1": {String: "1"}, "tag2": {String: "fuga1"}, "val2": {String: "0.1"}},
+						{"tag1": {String: "hoge\"3"}, "val1": {String: "3"}, "tag2": {String: "fuga3"}, "val2": {String: "0.3"}},
+					},
+				},
+			},
+			rule: Rule{
+				Name:      "test2",
+				Interval:  (10 * time.Second),
+				Query:     "SELECT tag1, val1, tag2, val2 FROM table1",
+				Notifier:  "prometheus",
+				ValueCols: []string{"val1", "val2"},
+				TagCols:   []string{"tag1", "tag2"},
+			},
+			out: "# TYPE test2_val1 gauge\n" +
+				"test2_val1{tag1=\"hoge1\",tag2=\"fuga1\"} 1\n" +
+				"test2_val1{tag1=\"hoge\\\"3\",tag2=\"fuga3\"} 3\n" +
+				"# TYPE test2_val2 gauge\n" +
+				"test2_val2{tag1=\"hoge1\",tag2=\"fuga1\"} 0.1\n" +
+				"test2_val2{tag1=\"hoge\\\"3\",tag2=\"fuga3\"} 0.3\n",
+		},
+		{
+			name:      "disappeared",
+			namespace: "",
+			puts: []QueryResult{
+				{
+					Records: []Record{
+						{"tag1": {String: "hoge1"}, "val1": {String: "1"}},
+						{"tag1": {String: "hoge2"}, "val1": {String: "2"}},
+					},
+				},
+				{
+					Records: []Record{
+						{"tag1": {String: "hoge2"}, "val1": {String: "20"}},
+					},
+				},
+			},
+			rule: Rule{
+				Name:      "test3",
+				Interval:  (10 * time.Second),
+				Query:     "SELECT tag1, val1 FROM table1",
+				Notifier:  "prometheus",
+				ValueCols: []string{"val1"},
+				TagCols:   []string{"tag1"},
+			},
+			out: "# TYPE test3_val1 gauge\n" +
+				"test3_val1{tag1=\"hoge2\"} 20\n",
+		},
+		{
+			name:      "duplicate",
+			namespace: "",
+			puts: []QueryResult{
+				{
+					Records: []Record{
+						{"tag1": {String: "hoge1"}, "val1": {String: "1"}},
+						{"tag1": {String: "hoge2"}, "val1": {String: "2"}},
+						{"tag1": {String: "hoge1"}, "val1": {String: "10"}},
+					},
+				},
+			},
+			rule: Rule{
+				Name:      "test4",
+				Interval:  (10 * time.Second),
+				Query:     "SELECT tag1, val1 FROM table1",
+				Notifier:  "prometheus",
+				ValueCols: []string{"val1"},
+				TagCols:   []string{"tag1"},
+			},
+			out: "# TYPE test4_val1 gauge\n" +
+				"test4_val1{tag1=\"hoge1\"} 10\n" +
+				"test4_val1{tag1=\"hoge2\"} 2\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newPrometheusExporter(tc.namespace)
+			for _, qr := range tc.puts {
+				if err := p.Put(context.Background(), qr, tc.rule); err != nil {
+					t.Fatalf("Prometheus.Put(%+v, %+v) returns unexpected err = %+v", qr, tc.rule, err)
+				}
+			}
+
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+			body, _ := io.ReadAll(rec.Body)
+
+			if string(body) != tc.out {
+				t.Errorf("Prometheus.ServeHTTP()\n got = %q,\nwant = %q", body, tc.out)
+			}
+		})
+	}
+}
+
+func TestPrometheusEvent(t *testing.T) {
+	p := newPrometheusExporter("ns")
+	events := []*Event{
+		{Title: "default", Text: "fuga"},
+		{Title: "error", Text: "fuga", Level: "error"},
+		{Title: "error", Text: "fuga", Level: "error"},
+	}
+	for _, e := range events {
+		if err := p.Event(context.Background(), e); err != nil {
+			t.Errorf("Prometheus.Event(%+v) returns unexpected err = %+v", e, err)
+		}
+	}
+
+	want := "# TYPE ns_cyqldog_events_total counter\n" +
+		"ns_cyqldog_events_total{level=\"error\"} 2\n" +
+		"ns_cyqldog_events_total{level=\"info\"} 1\n"
+	if got := p.render(); got != want {
+		t.Errorf("Prometheus.render()\n got = %q,\nwant = %q", got, want)
+	}
+}
+
+func TestPrometheusPutMetrics(t *testing.T) {
+	p := newPrometheusExporter("ns")
+	tags := []string{"rule:test", "data_source:default"}
+	errTags := []string{"rule:test", "data_source:default", "error_class:query"}
+
+	for i := 0; i < 2; i++ {
+		metrics := []metric{
+			{name: "cyqldog.check.rows", value: float64(i), tags: tags, kind: MetricGauge},
+			{name: "cyqldog.check.errors", value: 1, tags: errTags, kind: MetricCount},
+		}
+		if err := p.putMetrics(context.Background(), metrics); err != nil {
+			t.Errorf("Prometheus.putMetrics(%+v) returns unexpected err = %+v", metrics, err)
+		}
+	}
+
+	want := "# TYPE ns_cyqldog_check_errors_total counter\n" +
+		"ns_cyqldog_check_errors_total{rule=\"test\",data_source=\"default\",error_class=\"query\"} 2\n" +
+		"# TYPE ns_cyqldog_check_rows gauge\n" +
+		"ns_cyqldog_check_rows{rule=\"test\",data_source=\"default\"} 1\n"
+	if got := p.render(); got != want {
+		t.Errorf("Prometheus.render()\n got = %q,\nwant = %q", got, want)
+	}
+}
+
+func TestPrometheusForget(t *testing.T) {
+	p := newPrometheusExporter("")
+	for _, name := range []string{"removed", "kept"} {
+		rule := Rule{Name: name, ValueCols: []string{"count"}}
+		qr := QueryResult{Records: []Record{{"count": {String: "1"}}}}
+		if err := p.Put(context.Background(), qr, rule); err != nil {
+			t.Fatalf("Prometheus.Put(%+v) returns unexpected err = %+v", rule, err)
+		}
+		metrics := []metric{{name: "cyqldog.check.rows", value: 1, tags: []string{"rule:" + name}, kind: MetricGauge}}
+		if err := p.putMetrics(context.Background(), metrics); err != nil {
+			t.Fatalf("Prometheus.putMetrics(%+v) returns unexpected err = %+v", metrics, err)
+		}
+	}
+
+	// The samples of the removed rule are not served anymore.
+	p.forget("removed")
+
+	want := "# TYPE cyqldog_check_rows gauge\n" +
+		"cyqldog_check_rows{rule=\"kept\"} 1\n" +
+		"# TYPE kept_count gauge\n" +
+		"kept_count 1\n"
+	if got := p.render(); got != want {
+		t.Errorf("Prometheus.render() after forget\n got = %q,\nwant = %q", got, want)
+	}
+}
+
+func TestSanitizePrometheusName(t *testing.T) {
+	cases := []struct {
+		in     string
+		metric bool
+		out    string
+	}{
+		{in: "ns.test1.count", metric: true, out: "ns_test1_count"},
+		{in: "ns:test-1", metric: true, out: "ns:test_1"},
+		{in: "ns:test-1", metric: false, out: "ns_test_1"},
+		{in: "1st", metric: false, out: "_1st"},
+	}
+
+	for _, tc := range cases {
+		if got := sanitizePrometheusName(tc.in, tc.metric); got != tc.out {
+			t.Errorf("sanitizePrometheusName(%s, %v) = %s, want = %s", tc.in, tc.metric, got, tc.out)
+		}
+	}
+}
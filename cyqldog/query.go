@@ -0,0 +1,162 @@
+package cyqldog
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// readKeywords are the keywords which read-only queries can start with.
+var readKeywords = map[string]bool{
+	"SELECT":   true,
+	"WITH":     true,
+	"VALUES":   true,
+	"TABLE":    true,
+	"SHOW":     true,
+	"EXPLAIN":  true,
+	"DESCRIBE": true,
+	"DESC":     true,
+}
+
+// writeKeywords are the keywords of DML and DDL rejected anywhere in read-only queries.
+var writeKeywords = map[string]bool{
+	"INSERT":   true,
+	"UPDATE":   true,
+	"DELETE":   true,
+	"MERGE":    true,
+	"UPSERT":   true,
+	"CREATE":   true,
+	"ALTER":    true,
+	"DROP":     true,
+	"TRUNCATE": true,
+	"RENAME":   true,
+	"GRANT":    true,
+	"REVOKE":   true,
+}
+
+// sqlWord matches a keyword or an identifier.
+var sqlWord = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_$]*`)
+
+// checkReadOnlyQuery returns an error if the query obviously writes to the database.
+// It rejects multiple statements, statements not starting with a keyword to read,
+// and the keywords of DML and DDL outside of literals and comments.
+// The driver decides how the literals are quoted.
+// This is only a guard against mistakes, and the read-only transaction is the actual restriction.
+func checkReadOnlyQuery(query string, driver string) error {
+	stripped := stripSQLLiterals(query, driver)
+
+	statements := 0
+	for _, s := range strings.Split(stripped, ";") {
+		if len(strings.TrimSpace(s)) > 0 {
+			statements++
+		}
+	}
+	if statements > 1 {
+		return xerrors.New("multiple statements are not allowed in read-only queries")
+	}
+
+	words := sqlWord.FindAllString(stripped, -1)
+	if len(words) == 0 || !readKeywords[strings.ToUpper(words[0])] {
+		return xerrors.New("read-only queries must start with SELECT, WITH, VALUES, TABLE, SHOW, EXPLAIN or DESCRIBE")
+	}
+	for _, w := range words {
+		if writeKeywords[strings.ToUpper(w)] {
+			return xerrors.Errorf("%s is not allowed in read-only queries", strings.ToUpper(w))
+		}
+	}
+	return nil
+}
+
+// dollarQuote matches the opening delimiter of a dollar-quoted string of PostgreSQL.
+var dollarQuote = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+// stripSQLLiterals replaces string literals, quoted identifiers and comments with spaces,
+// so that their contents are not taken as keywords or separators.
+// MySQL also has # comments, and takes -- as a comment only when followed by a whitespace.
+// A backslash escapes the next character in the strings of MySQL and the escape strings (E'...') of PostgreSQL,
+// and PostgreSQL also has dollar-quoted strings ($tag$...$tag$).
+// The delimiters are all ASCII, so the query is scanned by bytes without breaking multibyte characters.
+func stripSQLLiterals(query string, driver string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			// Skip to the closing quote. A doubled quote is an escaped one.
+			escapes := (driver == "mysql" && c != '`') || (driver == "postgres" && c == '\'' && isEscapeString(query, i))
+			for i++; i < len(query); i++ {
+				if escapes && query[i] == '\\' {
+					i++
+					continue
+				}
+				if query[i] == c {
+					if i+1 < len(query) && query[i+1] == c {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			b.WriteByte(' ')
+		case driver == "postgres" && c == '$' && len(dollarQuoteTag(query, i)) > 0:
+			// Skip to the closing delimiter with the same tag.
+			tag := dollarQuoteTag(query, i)
+			i += len(tag)
+			if end := strings.Index(query[i:], tag); end >= 0 {
+				i += end + len(tag) - 1
+			} else {
+				i = len(query)
+			}
+			b.WriteByte(' ')
+		case isLineComment(query, i, driver):
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+			b.WriteByte('\n')
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			for i += 2; i < len(query) && !(query[i] == '*' && i+1 < len(query) && query[i+1] == '/'); i++ {
+			}
+			i++
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// isLineComment returns true if a comment to the end of the line starts at i.
+// MySQL also takes # as a line comment, but -- only when followed by a whitespace or a control character,
+// so 1--1 is a subtraction of a negative number.
+func isLineComment(query string, i int, driver string) bool {
+	if driver == "mysql" && query[i] == '#' {
+		return true
+	}
+	if query[i] != '-' || i+1 >= len(query) || query[i+1] != '-' {
+		return false
+	}
+	return driver != "mysql" || i+2 >= len(query) || query[i+2] <= ' '
+}
+
+// isEscapeString returns true if the quote at i starts an escape string (E'...') of PostgreSQL.
+func isEscapeString(query string, i int) bool {
+	return i > 0 && (query[i-1] == 'E' || query[i-1] == 'e') && (i == 1 || !isSQLWordByte(query[i-2]))
+}
+
+// dollarQuoteTag returns the delimiter of the dollar-quoted string starting at i, or empty if not.
+// A dollar sign in an identifier such as a$b does not start a string.
+func dollarQuoteTag(query string, i int) string {
+	if i > 0 && isSQLWordByte(query[i-1]) {
+		return ""
+	}
+	return dollarQuote.FindString(query[i:])
+}
+
+// isSQLWordByte returns true if the byte can be a part of a keyword or an identifier.
+func isSQLWordByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
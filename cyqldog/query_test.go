@@ -0,0 +1,50 @@
+package cyqldog
+
+import "testing"
+
+func TestCheckReadOnlyQuery(t *testing.T) {
+	cases := []struct {
+		driver string
+		query  string
+		ok     bool
+	}{
+		{driver: "postgres", query: "SELECT COUNT(*) AS count FROM table1", ok: true},
+		{driver: "postgres", query: "SELECT COUNT(*) AS count FROM table1;", ok: true},
+		{driver: "postgres", query: "  with t AS (SELECT 1 AS count) SELECT count FROM t", ok: true},
+		{driver: "postgres", query: "SELECT updated_at, REPLACE(name, 'a', 'b') AS name FROM table1", ok: true},
+		{driver: "postgres", query: "SELECT 'DELETE FROM table1; DROP TABLE table1' AS message", ok: true},
+		{driver: "postgres", query: `SELECT "delete" FROM table1 -- DELETE FROM table1`, ok: true},
+		{driver: "postgres", query: "SELECT 'it''s; DROP' /* ; DELETE */ AS message", ok: true},
+		{driver: "postgres", query: "DELETE FROM table1", ok: false},
+		{driver: "postgres", query: "SELECT 1; DELETE FROM table1", ok: false},
+		{driver: "postgres", query: "SELECT 1 AS count; SELECT 2 AS count", ok: false},
+		{driver: "postgres", query: "WITH d AS (DELETE FROM table1 RETURNING id) SELECT COUNT(*) FROM d", ok: false},
+		{driver: "postgres", query: "SELECT * FROM table1 FOR UPDATE", ok: false},
+		{driver: "postgres", query: "SET TRANSACTION READ WRITE", ok: false},
+		{driver: "postgres", query: "CALL cleanup()", ok: false},
+		{driver: "postgres", query: "-- only a comment", ok: false},
+		// A backslash is not an escape in the standard strings of PostgreSQL.
+		{driver: "postgres", query: `SELECT 'C:\' AS path FROM table1 WHERE name = 'DELETE'`, ok: true},
+		{driver: "postgres", query: `SELECT E'it\'s; DELETE' AS message`, ok: true},
+		{driver: "postgres", query: `SELECT e'\\'; DELETE FROM table1`, ok: false},
+		{driver: "postgres", query: "SELECT $$DELETE FROM table1; DROP TABLE table1$$ AS body", ok: true},
+		{driver: "postgres", query: "SELECT $body$ it's $$; DELETE $body$ AS body", ok: true},
+		{driver: "postgres", query: "SELECT $x$ DELETE $x$; DELETE FROM table1", ok: false},
+		{driver: "postgres", query: "SELECT a$b$ FROM table1; DELETE FROM table1", ok: false},
+		{driver: "mysql", query: `SELECT 'it\'s; DROP TABLE table1' AS message`, ok: true},
+		{driver: "mysql", query: `SELECT "say \"DELETE\"" AS message`, ok: true},
+		{driver: "mysql", query: `SELECT 'C:\\'; DELETE FROM table1`, ok: false},
+		{driver: "mysql", query: "SELECT `delete` FROM table1", ok: true},
+		{driver: "mysql", query: "SELECT $$ DELETE $$ AS body", ok: false},
+		{driver: "mysql", query: "SELECT 1 AS count # it's\n; DELETE FROM table1", ok: false},
+		{driver: "mysql", query: "SELECT 1--1 AS count; DELETE FROM table1", ok: false},
+		{driver: "mysql", query: "SELECT 1 AS count -- it's\n", ok: true},
+	}
+
+	for _, tc := range cases {
+		err := checkReadOnlyQuery(tc.query, tc.driver)
+		if ok := err == nil; ok != tc.ok {
+			t.Errorf("checkReadOnlyQuery(%q, %s) returns err = %v, want ok = %v", tc.query, tc.driver, err, tc.ok)
+		}
+	}
+}
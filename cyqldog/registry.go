@@ -0,0 +1,113 @@
+package cyqldog
+
+import (
+	"sort"
+	"sync"
+)
+
+// NotifierFactory returns a Notifier of a registered type.
+// The unmarshal decodes the configuration of the notifier in the yaml into the given value,
+// in the same way as yaml.Unmarshaler.
+type NotifierFactory func(unmarshal func(interface{}) error) (Notifier, error)
+
+// DataSourceFactory returns a DataSource of a registered driver.
+// The factory is expected to verify the connection like the built-in drivers.
+type DataSourceFactory func(c DataSourceConfig) (DataSource, error)
+
+// registry holds the notifiers and the data sources registered by library users.
+var registry = struct {
+	mu          sync.RWMutex
+	notifiers   map[string]NotifierFactory
+	dataSources map[string]DataSourceFactory
+}{
+	notifiers:   make(map[string]NotifierFactory),
+	dataSources: make(map[string]DataSourceFactory),
+}
+
+// RegisterNotifier makes a notifier type available in the configuration file.
+// Notifiers with `type: <typ>` are created by the factory.
+// If RegisterNotifier is called twice with the same type,
+// or the type is one of the built-in types, it panics.
+func RegisterNotifier(typ string, factory NotifierFactory) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if factory == nil {
+		panic("cyqldog: RegisterNotifier factory is nil: " + typ)
+	}
+	for _, t := range notifierTypes {
+		if typ == t {
+			panic("cyqldog: RegisterNotifier cannot override the built-in type: " + typ)
+		}
+	}
+	if _, dup := registry.notifiers[typ]; dup {
+		panic("cyqldog: RegisterNotifier called twice for type: " + typ)
+	}
+	registry.notifiers[typ] = factory
+}
+
+// RegisterDataSource makes a database driver available in the configuration file.
+// Data sources with `driver: <driver>` are created by the factory.
+// If RegisterDataSource is called twice with the same driver,
+// or the driver is one of the built-in drivers, it panics.
+func RegisterDataSource(driver string, factory DataSourceFactory) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if factory == nil {
+		panic("cyqldog: RegisterDataSource factory is nil: " + driver)
+	}
+	for _, d := range supportedDrivers {
+		if driver == d {
+			panic("cyqldog: RegisterDataSource cannot override the built-in driver: " + driver)
+		}
+	}
+	if _, dup := registry.dataSources[driver]; dup {
+		panic("cyqldog: RegisterDataSource called twice for driver: " + driver)
+	}
+	registry.dataSources[driver] = factory
+}
+
+// lookupNotifier returns the factory of the registered notifier type.
+func lookupNotifier(typ string) (NotifierFactory, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	f, ok := registry.notifiers[typ]
+	return f, ok
+}
+
+// lookupDataSource returns the factory of the registered driver.
+func lookupDataSource(driver string) (DataSourceFactory, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	f, ok := registry.dataSources[driver]
+	return f, ok
+}
+
+// allNotifierTypes returns the built-in and the registered notifier types.
+func allNotifierTypes() []string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	types := append([]string{}, notifierTypes...)
+	registered := make([]string, 0, len(registry.notifiers))
+	for t := range registry.notifiers {
+		registered = append(registered, t)
+	}
+	sort.Strings(registered)
+	return append(types, registered...)
+}
+
+// allDrivers returns the built-in and the registered database drivers.
+func allDrivers() []string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	drivers := append([]string{}, supportedDrivers...)
+	registered := make([]string, 0, len(registry.dataSources))
+	for d := range registry.dataSources {
+		registered = append(registered, d)
+	}
+	sort.Strings(registered)
+	return append(drivers, registered...)
+}
@@ -0,0 +1,127 @@
+package cyqldog
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// registeredNotifier is a notifier of a registered type for testing.
+type registeredNotifier struct {
+	mockNotifier
+	config struct {
+		Endpoint string `yaml:"endpoint"`
+	}
+}
+
+// registeredDataSource is a data source of a registered driver for testing.
+type registeredDataSource struct {
+	mockDataSource
+	config DataSourceConfig
+}
+
+func init() {
+	RegisterNotifier("test_registry", func(unmarshal func(interface{}) error) (Notifier, error) {
+		n := &registeredNotifier{}
+		if err := unmarshal(&n.config); err != nil {
+			return nil, err
+		}
+		return n, nil
+	})
+	RegisterDataSource("test_registry", func(c DataSourceConfig) (DataSource, error) {
+		return &registeredDataSource{config: c}, nil
+	})
+}
+
+func TestRegistry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cyqldog.yml")
+	writeConfig(t, path, `
+data_source:
+  driver: test_registry
+  options:
+    endpoint: db.example.com
+
+notifiers:
+  custom:
+    type: test_registry
+    endpoint: https://example.com
+
+rules:
+  - name: test1
+    interval: 1h
+    query: "SELECT 1 AS count"
+    notifier: custom
+    value_cols: [count]
+`)
+
+	config, err := newConfig(path)
+	if err != nil {
+		t.Fatalf("newConfig() returns unexpected err = %+v", err)
+	}
+
+	notifiers, err := newNotifiers(config.Notifiers)
+	if err != nil {
+		t.Fatalf("newNotifiers() returns unexpected err = %+v", err)
+	}
+	n, ok := notifiers["custom"].(*registeredNotifier)
+	if !ok {
+		t.Fatalf("newNotifiers() returns %T, want = *registeredNotifier", notifiers["custom"])
+	}
+	if n.config.Endpoint != "https://example.com" {
+		t.Errorf("newNotifiers() decodes endpoint = %q, want = %q", n.config.Endpoint, "https://example.com")
+	}
+
+	ds, err := newDB(context.Background(), config.DB)
+	if err != nil {
+		t.Fatalf("newDB() returns unexpected err = %+v", err)
+	}
+	d, ok := ds.(*registeredDataSource)
+	if !ok {
+		t.Fatalf("newDB() returns %T, want = *registeredDataSource", ds)
+	}
+	if want := (DataSourceOptions{"endpoint": "db.example.com"}); !reflect.DeepEqual(d.config.Options, want) {
+		t.Errorf("newDB() passes options = %v, want = %v", d.config.Options, want)
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	cases := []struct {
+		name     string
+		register func()
+	}{
+		{
+			name: "duplicate notifier",
+			register: func() {
+				RegisterNotifier("test_registry", func(func(interface{}) error) (Notifier, error) { return nil, nil })
+			},
+		},
+		{
+			name: "built-in notifier",
+			register: func() {
+				RegisterNotifier("dogstatsd", func(func(interface{}) error) (Notifier, error) { return nil, nil })
+			},
+		},
+		{
+			name: "duplicate data source",
+			register: func() {
+				RegisterDataSource("test_registry", func(DataSourceConfig) (DataSource, error) { return nil, nil })
+			},
+		},
+		{
+			name:     "built-in data source",
+			register: func() { RegisterDataSource("postgres", func(DataSourceConfig) (DataSource, error) { return nil, nil }) },
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("register %s does not panic", tc.name)
+				}
+			}()
+			tc.register()
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package cyqldog
 
-import "time"
+import (
+	"time"
+
+	"golang.org/x/xerrors"
+)
 
 // Rule represents monitoring conditions.
 type Rule struct {
@@ -10,12 +14,146 @@ type Rule struct {
 	Name string `yaml:"name"`
 	// Interval of the monitoring.
 	Interval time.Duration `yaml:"interval"`
+	// Schedule of the monitoring in the cron syntax.
+	// Either Interval or Schedule can be set.
+	Schedule string `yaml:"schedule"`
+	// Timezone is a name of location to evaluate Schedule. (default: local)
+	Timezone string `yaml:"timezone"`
 	// Query to the database.
 	Query string `yaml:"query"`
+	// Timeout of the query.
+	// If zero, Config.Timeout is used.
+	Timeout time.Duration `yaml:"timeout"`
+	// DataSource is a name of data source to query.
+	// If empty, the data source named "default" is used.
+	DataSource string `yaml:"data_source"`
 	// Notifier is a name of notifier to send metrics.
+	// Either Notifier or Notifiers can be set.
 	Notifier string `yaml:"notifier"`
+	// Notifiers is a list of names of notifiers to send metrics to each.
+	Notifiers []string `yaml:"notifiers"`
+	// Channel overrides the channel to post the events of the rule to.
+	// This is only supported by the slack notifier.
+	Channel string `yaml:"channel"`
 	// ValueCols is a list of names of the columns used as metric values.
 	ValueCols []string `yaml:"value_cols"`
 	// TagCols is a list of names of the columns used as metric tags.
 	TagCols []string `yaml:"tag_cols"`
+	// ValueTypes are the metric types of ValueCols by column name.
+	// Types other than gauge are supported only by the dogstatsd notifier.
+	ValueTypes map[string]ValueType `yaml:"value_types"`
+	// NullValue is a policy to handle NULL in ValueCols.
+	NullValue NullValuePolicy `yaml:"null_value"`
+	// NullTag is a placeholder of NULL in TagCols. (default: null)
+	NullTag string `yaml:"null_tag"`
+	// Alerts are threshold conditions of ValueCols.
+	// When the level of an alert changes, an event is sent to the Notifier.
+	Alerts []Alert `yaml:"alerts"`
+	// TimeFormat is a layout to format timestamps in TagCols. (default: RFC3339)
+	// The layout is the same as the time package of Go. (e.g. 2006-01-02)
+	TimeFormat string `yaml:"time_format"`
+}
+
+// Policies to handle NULL in value columns.
+const (
+	// NullValueFail fails the rule and sends an error event.
+	NullValueFail = "fail"
+	// NullValueSkip skips the metric.
+	NullValueSkip = "skip"
+	// NullValueDefault sends the default number instead.
+	NullValueDefault = "default"
+)
+
+// NullValuePolicy is a policy to handle NULL in value columns.
+type NullValuePolicy struct {
+	// Policy is one of fail, skip or default. (default: fail)
+	Policy string `yaml:"policy"`
+	// Default is a number sent instead of NULL when Policy is default.
+	Default float64 `yaml:"default"`
+}
+
+// policy returns the policy with the default applied.
+func (p NullValuePolicy) policy() string {
+	if len(p.Policy) == 0 {
+		return NullValueFail
+	}
+	return p.Policy
+}
+
+// Types of metrics.
+const (
+	// MetricGauge is the latest value.
+	MetricGauge = "gauge"
+	// MetricCount is added up across sends.
+	MetricCount = "count"
+	// MetricHistogram is aggregated into a distribution on each host.
+	MetricHistogram = "histogram"
+	// MetricDistribution is aggregated into a distribution across hosts.
+	MetricDistribution = "distribution"
+	// MetricSet counts the unique values.
+	MetricSet = "set"
+)
+
+// metricTypes are the supported types of metrics.
+var metricTypes = []string{MetricGauge, MetricCount, MetricHistogram, MetricDistribution, MetricSet}
+
+// ValueType is a metric type of a value column.
+type ValueType struct {
+	// Type is one of gauge, count, histogram, distribution or set. (default: gauge)
+	Type string `yaml:"type"`
+	// SampleRate is a rate to sample the metric between 0 and 1. (default: 1)
+	SampleRate float64 `yaml:"sample_rate"`
+}
+
+// defaultNullTag is a default placeholder of NULL in tag columns.
+const defaultNullTag = "null"
+
+// timeFormat returns a layout to format timestamps in tag columns.
+func (r Rule) timeFormat() string {
+	if len(r.TimeFormat) == 0 {
+		return time.RFC3339
+	}
+	return r.TimeFormat
+}
+
+// nullTag returns a placeholder of NULL in tag columns.
+func (r Rule) nullTag() string {
+	if len(r.NullTag) == 0 {
+		return defaultNullTag
+	}
+	return r.NullTag
+}
+
+// dataSourceName returns a name of data source to query.
+func (r Rule) dataSourceName() string {
+	if len(r.DataSource) == 0 {
+		return defaultDataSourceName
+	}
+	return r.DataSource
+}
+
+// notifierNames returns the names of notifiers to send metrics.
+func (r Rule) notifierNames() []string {
+	if len(r.Notifiers) > 0 {
+		return r.Notifiers
+	}
+	return []string{r.Notifier}
+}
+
+// cronSchedule returns the parsed Schedule.
+func (r Rule) cronSchedule() (*cronSchedule, error) {
+	loc := time.Local
+	if len(r.Timezone) > 0 {
+		l, err := time.LoadLocation(r.Timezone)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to load timezone: rule = %s, timezone = %s: %w", r.Name, r.Timezone, err)
+		}
+		loc = l
+	}
+
+	s, err := parseCronSchedule(r.Schedule, loc)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to parse schedule: rule = %s: %w", r.Name, err)
+	}
+	return s, nil
 }
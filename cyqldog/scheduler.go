@@ -1,6 +1,7 @@
 package cyqldog
 
 import (
+	"context"
 	"log"
 	"time"
 )
@@ -9,38 +10,157 @@ import (
 type Scheduler struct {
 	id   int
 	rule Rule
+	// cron is the parsed schedule of the rule.
+	// If nil, the rule is triggered at the fixed interval.
+	cron *cronSchedule
+	// status records the next scheduled time of the rule.
+	status *statusTracker
+
+	// cancel stops the running scheduler.
+	cancel context.CancelFunc
+	// stopped is closed when the running scheduler exits.
+	stopped chan struct{}
+}
+
+// task is a monitoring job enqueued by the Scheduler.
+type task struct {
+	rule Rule
+	// enqueued is when the task was created, to measure the wait in the queue.
+	enqueued time.Time
+	// done is closed by the Checker when the check finishes.
+	done chan struct{}
+}
+
+// newTask returns an instance of task.
+func newTask(rule Rule) task {
+	return task{
+		rule:     rule,
+		enqueued: time.Now(),
+		done:     make(chan struct{}),
+	}
 }
 
 // newScheduler returns an instance of Scheduler.
-func newScheduler(id int, rule Rule) *Scheduler {
-	return &Scheduler{
-		id:   id,
-		rule: rule,
+func newScheduler(id int, rule Rule, status *statusTracker) (*Scheduler, error) {
+	s := &Scheduler{
+		id:     id,
+		rule:   rule,
+		status: status,
+	}
+
+	if len(rule.Schedule) > 0 {
+		cron, err := rule.cronSchedule()
+		if err != nil {
+			return nil, err
+		}
+		s.cron = cron
 	}
+
+	return s, nil
+}
+
+// start runs the scheduler in a new goroutine.
+func (s *Scheduler) start(q chan<- task) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.stopped = make(chan struct{})
+
+	go func() {
+		defer close(s.stopped)
+		s.run(ctx, q)
+	}()
+}
+
+// stop stops the scheduler started by start.
+// It waits for the running check of the rule to finish,
+// so that a restarted scheduler never overlaps with the previous one.
+func (s *Scheduler) stop() {
+	s.cancel()
+	<-s.stopped
+	log.Printf("scheduler(%d): stopped: %s", s.id, s.rule.Name)
 }
 
-// run periodically generates monitoring tasks according to the rule.
-func (s *Scheduler) run(q chan<- Rule) {
+// run periodically generates monitoring tasks according to the rule until the ctx is done.
+func (s *Scheduler) run(ctx context.Context, q chan<- task) {
 	log.Printf("scheduler(%d): start", s.id)
 
 	// Generate trigger periodically.
-	t := time.NewTicker(s.rule.Interval)
-	defer t.Stop()
+	c, stop := s.trigger()
+	defer stop()
 
 	// If the monitoring interval is long,
 	// it will take time to check whether it is in the normal state,
 	// so monitor once after startup.
 	log.Printf("scheduler(%d): check on startup: %s", s.id, s.rule.Name)
-	q <- s.rule
+	s.enqueue(ctx, q)
 
 	for {
-		<-t.C
+		select {
+		case fired := <-c:
+			if s.cron == nil {
+				s.status.scheduled(s.rule, fired.Add(s.rule.Interval))
+			}
+		case <-ctx.Done():
+			return
+		}
 		log.Printf("scheduler(%d): triggered: %s", s.id, s.rule.Name)
-		// So as not to consume the database connection simultaneously
+		// So as not to consume more database connections than the limit
 		// among the schedulers with different intervals,
-		// we put a task in the queue and serialize the monitoring.
-		// Taking into account the case of the monitoring query is slow,
-		// block here without buffers to prevent duplicate monitoring tasks.
-		q <- s.rule
+		// we put a task in the queue shared by the checkers of the data source.
+		s.enqueue(ctx, q)
+	}
+}
+
+// trigger returns a channel which fires according to the rule and a function to stop it.
+// Like time.Ticker, it drops triggers while the receiver is busy.
+func (s *Scheduler) trigger() (<-chan time.Time, func()) {
+	if s.cron == nil {
+		t := time.NewTicker(s.rule.Interval)
+		s.status.scheduled(s.rule, time.Now().Add(s.rule.Interval))
+		return t.C, t.Stop
+	}
+
+	c := make(chan time.Time, 1)
+	done := make(chan struct{})
+	go func() {
+		for {
+			now := time.Now()
+			next := s.cron.next(now)
+			if next.IsZero() {
+				log.Printf("scheduler(%d): no next time matches the schedule: %s", s.id, s.rule.Schedule)
+				return
+			}
+			log.Printf("scheduler(%d): next: %s at %s", s.id, s.rule.Name, next)
+			s.status.scheduled(s.rule, next)
+
+			t := time.NewTimer(next.Sub(now))
+			select {
+			case fired := <-t.C:
+				select {
+				case c <- fired:
+				default:
+				}
+			case <-done:
+				t.Stop()
+				return
+			}
+		}
+	}()
+
+	return c, func() { close(done) }
+}
+
+// enqueue puts a task in the queue and waits for the check to finish.
+// Taking into account the case of the monitoring query is slow,
+// block here without buffers to prevent duplicate monitoring tasks,
+// even if other checkers are idle.
+// If the ctx is done before a checker takes the task, the task is discarded.
+func (s *Scheduler) enqueue(ctx context.Context, q chan<- task) {
+	t := newTask(s.rule)
+	select {
+	case q <- t:
+	case <-ctx.Done():
+		return
 	}
+	<-t.done
 }
@@ -0,0 +1,55 @@
+package cyqldog
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSchedulerRunNoOverlap(t *testing.T) {
+	rule := Rule{Name: "test1", Interval: time.Millisecond}
+	q := make(chan task)
+
+	s, err := newScheduler(0, rule, newStatusTracker())
+	if err != nil {
+		t.Fatalf("newScheduler(%+v) returns unexpected err = %+v", rule, err)
+	}
+	go s.run(context.Background(), q)
+
+	// Emulate multiple idle checkers sharing the queue.
+	var mu sync.Mutex
+	running, maxRunning, count := 0, 0, 0
+	for i := 0; i < 3; i++ {
+		go func() {
+			for t := range q {
+				mu.Lock()
+				running++
+				count++
+				if running > maxRunning {
+					maxRunning = running
+				}
+				mu.Unlock()
+
+				// The check is slower than the interval.
+				time.Sleep(5 * time.Millisecond)
+
+				mu.Lock()
+				running--
+				mu.Unlock()
+				close(t.done)
+			}
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if count < 2 {
+		t.Errorf("Scheduler.run() enqueues %d tasks, want >= 2", count)
+	}
+	if maxRunning != 1 {
+		t.Errorf("Scheduler.run() runs %d tasks of the same rule at the same time, want = 1", maxRunning)
+	}
+}
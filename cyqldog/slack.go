@@ -0,0 +1,246 @@
+package cyqldog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// SlackConfig is a configuration of the slack incoming webhook to post events to.
+type SlackConfig struct {
+	// WebhookURL is an URL of the incoming webhook.
+	WebhookURL string `yaml:"webhook_url"`
+	// Channel overrides the default channel of the incoming webhook.
+	Channel string `yaml:"channel"`
+	// Username overrides the default username of the incoming webhook.
+	Username string `yaml:"username"`
+	// RateLimit is a minimum interval to post the same event again. (default: 10m)
+	// The events are the same if they have the same rule, level, tags and channel.
+	RateLimit time.Duration `yaml:"rate_limit"`
+	// Retries is the number of retries of failed requests.
+	Retries int `yaml:"retries"`
+	// Timeout is a timeout of each request. (default: 10s)
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// defaultSlackRateLimit is a default minimum interval to post the same event again.
+const defaultSlackRateLimit = 10 * time.Minute
+
+// rateLimit returns the rate limit with the default applied.
+func (c SlackConfig) rateLimit() time.Duration {
+	if c.RateLimit == 0 {
+		return defaultSlackRateLimit
+	}
+	return c.RateLimit
+}
+
+// slackColors are colors of the message for each event level.
+var slackColors = map[string]string{
+	"info":    "#439FE0",
+	"success": "good",
+	"warning": "warning",
+	"error":   "danger",
+}
+
+// Limits of the text in Block Kit.
+const (
+	slackHeaderLimit  = 150
+	slackSectionLimit = 3000
+)
+
+// Slack is a notifier which posts events to the slack incoming webhook.
+// Metrics are not posted.
+type Slack struct {
+	config  SlackConfig
+	webhook *Webhook
+
+	mu sync.Mutex
+	// limits are the rate limits of each event key.
+	limits map[string]*slackLimit
+
+	// now returns the current time.
+	// We make a layer of abstraction for testing.
+	now func() time.Time
+}
+
+// slackLimit is a state of the rate limit of an event key.
+type slackLimit struct {
+	// posted is when the event was posted last time.
+	posted time.Time
+	// suppressed is the number of events suppressed since then.
+	suppressed int
+}
+
+// newSlack returns an instance of Notifier interface.
+func newSlack(c SlackConfig) (Notifier, error) {
+	// The slack incoming webhook is a webhook taking a JSON body.
+	wc := WebhookConfig{
+		URL:     c.WebhookURL,
+		Retries: c.Retries,
+		Timeout: c.Timeout,
+	}
+
+	return &Slack{
+		config:  c,
+		webhook: &Webhook{config: wc, client: &http.Client{Timeout: wc.timeout()}},
+		limits:  make(map[string]*slackLimit),
+		now:     time.Now,
+	}, nil
+}
+
+// slackMessage is a message of the incoming webhook.
+type slackMessage struct {
+	Channel     string            `json:"channel,omitempty"`
+	Username    string            `json:"username,omitempty"`
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+// slackAttachment is an attachment to color the blocks.
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+// slackBlock is a block of Block Kit.
+type slackBlock struct {
+	Type     string      `json:"type"`
+	Text     *slackText  `json:"text,omitempty"`
+	Elements []slackText `json:"elements,omitempty"`
+}
+
+// slackText is a text object of Block Kit.
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Put does nothing because slack receives only events.
+func (s *Slack) Put(ctx context.Context, qr QueryResult, rule Rule) error {
+	return nil
+}
+
+// Event posts the event to slack unless the same event was posted within the rate limit.
+func (s *Slack) Event(ctx context.Context, e *Event) error {
+	channel := e.Channel
+	if len(channel) == 0 {
+		channel = s.config.Channel
+	}
+
+	key := slackEventKey(e, channel)
+	prev, ok := s.allow(key)
+	if !ok {
+		log.Printf("slack: rate limited: %s", e.Title)
+		return nil
+	}
+
+	body, err := json.Marshal(s.message(e, channel, prev.suppressed))
+	if err != nil {
+		s.rollback(key, prev)
+		return xerrors.Errorf("failed to encode slack message: %w", err)
+	}
+
+	if err := s.webhook.post(ctx, body); err != nil {
+		// The event is not posted, so that the retry of it is not rate limited.
+		s.rollback(key, prev)
+		return xerrors.Errorf("failed to post to slack: %w", err)
+	}
+	return nil
+}
+
+// slackEventKey returns a key to identify the same events for the rate limit.
+// Events not related to a rule are identified by their title.
+func slackEventKey(e *Event, channel string) string {
+	id := e.Rule
+	if len(id) == 0 {
+		id = e.Title
+	}
+
+	tags := append([]string{}, e.Tags...)
+	sort.Strings(tags)
+	return strings.Join([]string{channel, id, e.Level, strings.Join(tags, ",")}, "\x00")
+}
+
+// allow returns true if the event of the key can be posted now,
+// with the limit before the post, which has the number of the same events suppressed since the last post.
+// The post is recorded until it is rolled back on failure.
+func (s *Slack) allow(key string) (slackLimit, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	l, ok := s.limits[key]
+	if ok && now.Sub(l.posted) < s.config.rateLimit() {
+		l.suppressed++
+		return slackLimit{}, false
+	}
+
+	var prev slackLimit
+	if ok {
+		prev = *l
+	}
+	s.limits[key] = &slackLimit{posted: now}
+	return prev, true
+}
+
+// rollback restores the limit of the key before the failed post.
+// The events suppressed while posting are kept counted.
+func (s *Slack) rollback(key string, prev slackLimit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	suppressed := prev.suppressed
+	if l, ok := s.limits[key]; ok {
+		suppressed += l.suppressed
+	}
+	s.limits[key] = &slackLimit{posted: prev.posted, suppressed: suppressed}
+}
+
+// message builds a Block Kit message of the event.
+func (s *Slack) message(e *Event, channel string, suppressed int) slackMessage {
+	level := e.Level
+	if len(level) == 0 {
+		level = "info"
+	}
+
+	blocks := []slackBlock{
+		{Type: "header", Text: &slackText{Type: "plain_text", Text: truncate(e.Title, slackHeaderLimit)}},
+		{Type: "section", Text: &slackText{Type: "mrkdwn", Text: "```" + truncate(e.Text, slackSectionLimit-6) + "```"}},
+	}
+
+	elements := []slackText{{Type: "mrkdwn", Text: "*" + level + "*"}}
+	if len(e.Tags) > 0 {
+		elements = append(elements, slackText{Type: "mrkdwn", Text: strings.Join(e.Tags, ", ")})
+	}
+	if suppressed > 0 {
+		elements = append(elements, slackText{Type: "mrkdwn", Text: fmt.Sprintf("%d similar events were suppressed", suppressed)})
+	}
+	blocks = append(blocks, slackBlock{Type: "context", Elements: elements})
+
+	return slackMessage{
+		Channel:  channel,
+		Username: s.config.Username,
+		// Text is shown in the notification.
+		Text: e.Title,
+		Attachments: []slackAttachment{
+			{Color: slackColors[level], Blocks: blocks},
+		},
+	}
+}
+
+// truncate shortens the string to the limit of characters.
+func truncate(s string, limit int) string {
+	r := []rune(s)
+	if len(r) <= limit {
+		return s
+	}
+	return string(r[:limit-1]) + "…"
+}
@@ -0,0 +1,182 @@
+package cyqldog
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSlackEvent(t *testing.T) {
+	ts, reqs := newWebhookServer(t)
+
+	n, err := newSlack(SlackConfig{WebhookURL: ts.URL, Channel: "#default", Username: "cyqldog", RateLimit: time.Minute})
+	if err != nil {
+		t.Fatalf("newSlack() returns unexpected err = %+v", err)
+	}
+	s := n.(*Slack)
+	now := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	failed := &Event{Title: "cyqldog: failed", Text: "connection refused", Level: "error", Tags: []string{"cyqldog"}, Rule: "test1"}
+	recovered := &Event{Title: "cyqldog: [recovered] test2.count", Text: "ok", Level: "success", Rule: "test2", Channel: "#db"}
+
+	steps := []struct {
+		event   *Event
+		elapsed time.Duration
+		// posted is the message posted, or nil if rate limited.
+		posted *slackMessage
+	}{
+		{
+			event: failed,
+			posted: &slackMessage{
+				Channel:  "#default",
+				Username: "cyqldog",
+				Text:     "cyqldog: failed",
+				Attachments: []slackAttachment{{
+					Color: "danger",
+					Blocks: []slackBlock{
+						{Type: "header", Text: &slackText{Type: "plain_text", Text: "cyqldog: failed"}},
+						{Type: "section", Text: &slackText{Type: "mrkdwn", Text: "```connection refused```"}},
+						{Type: "context", Elements: []slackText{{Type: "mrkdwn", Text: "*error*"}, {Type: "mrkdwn", Text: "cyqldog"}}},
+					},
+				}},
+			},
+		},
+		{event: failed, elapsed: 10 * time.Second},
+		{event: failed, elapsed: 10 * time.Second},
+		{
+			event: recovered,
+			posted: &slackMessage{
+				Channel:  "#db",
+				Username: "cyqldog",
+				Text:     "cyqldog: [recovered] test2.count",
+				Attachments: []slackAttachment{{
+					Color: "good",
+					Blocks: []slackBlock{
+						{Type: "header", Text: &slackText{Type: "plain_text", Text: "cyqldog: [recovered] test2.count"}},
+						{Type: "section", Text: &slackText{Type: "mrkdwn", Text: "```ok```"}},
+						{Type: "context", Elements: []slackText{{Type: "mrkdwn", Text: "*success*"}}},
+					},
+				}},
+			},
+		},
+		{
+			event:   failed,
+			elapsed: time.Minute,
+			posted: &slackMessage{
+				Channel:  "#default",
+				Username: "cyqldog",
+				Text:     "cyqldog: failed",
+				Attachments: []slackAttachment{{
+					Color: "danger",
+					Blocks: []slackBlock{
+						{Type: "header", Text: &slackText{Type: "plain_text", Text: "cyqldog: failed"}},
+						{Type: "section", Text: &slackText{Type: "mrkdwn", Text: "```connection refused```"}},
+						{Type: "context", Elements: []slackText{{Type: "mrkdwn", Text: "*error*"}, {Type: "mrkdwn", Text: "cyqldog"}, {Type: "mrkdwn", Text: "2 similar events were suppressed"}}},
+					},
+				}},
+			},
+		},
+	}
+
+	for i, step := range steps {
+		now = now.Add(step.elapsed)
+		before := len(*reqs)
+
+		if err := s.Event(context.Background(), step.event); err != nil {
+			t.Fatalf("steps[%d]: Slack.Event() returns unexpected err = %+v", i, err)
+		}
+
+		if step.posted == nil {
+			if len(*reqs) != before {
+				t.Errorf("steps[%d]: Slack.Event() posts a rate limited event", i)
+			}
+			continue
+		}
+		if len(*reqs) != before+1 {
+			t.Fatalf("steps[%d]: Slack.Event() posts %d messages, want = 1", i, len(*reqs)-before)
+		}
+
+		req := (*reqs)[before]
+		if req.method != http.MethodPost {
+			t.Errorf("steps[%d]: Slack.Event() sends method = %s, want = %s", i, req.method, http.MethodPost)
+		}
+		want, err := json.Marshal(step.posted)
+		if err != nil {
+			t.Fatalf("failed to encode: %v", err)
+		}
+		if req.body != string(want) {
+			t.Errorf("steps[%d]: Slack.Event() posts %s, want = %s", i, req.body, want)
+		}
+	}
+}
+
+func TestSlackEventRetry(t *testing.T) {
+	cases := []struct {
+		name     string
+		statuses []int
+		// posts is the number of the posts to slack.
+		posts    int
+		failures int64
+	}{
+		{
+			name:     "posted by retry",
+			statuses: []int{http.StatusInternalServerError, http.StatusInternalServerError},
+			posts:    3,
+			failures: 0,
+		},
+		{
+			name:     "failed",
+			statuses: []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError},
+			posts:    3,
+			failures: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts, reqs := newWebhookServer(t, tc.statuses...)
+			n, err := newSlack(SlackConfig{WebhookURL: ts.URL})
+			if err != nil {
+				t.Fatalf("newSlack() returns unexpected err = %+v", err)
+			}
+
+			path := filepath.Join(t.TempDir(), "dead_letter.jsonl")
+			config := ErrorEventsConfig{Retries: 2, Backoff: time.Millisecond, DeadLetter: path}
+			d := newEventDelivery(config, Notifiers{"slack": n}, InternalMetricsConfig{})
+
+			d.send(context.Background(), "slack", &Event{Title: "cyqldog: failed", Level: "error", Rule: "test1"})
+
+			if len(*reqs) != tc.posts {
+				t.Errorf("eventDelivery.send() posts to slack %d times, want = %d", len(*reqs), tc.posts)
+			}
+			if got := d.failures(); got != tc.failures {
+				t.Errorf("eventDelivery.failures() = %d, want = %d", got, tc.failures)
+			}
+			if _, err := os.Stat(path); (err == nil) != (tc.failures > 0) {
+				t.Errorf("eventDelivery.send() writes the dead letter = %t, want = %t", err == nil, tc.failures > 0)
+			}
+		})
+	}
+}
+
+func TestSlackPut(t *testing.T) {
+	ts, reqs := newWebhookServer(t)
+
+	s, err := newSlack(SlackConfig{WebhookURL: ts.URL})
+	if err != nil {
+		t.Fatalf("newSlack() returns unexpected err = %+v", err)
+	}
+
+	qr := QueryResult{Records: []Record{{"count": Value{String: "1"}}}}
+	if err := s.Put(context.Background(), qr, Rule{Name: "test1", ValueCols: []string{"count"}}); err != nil {
+		t.Fatalf("Slack.Put() returns unexpected err = %+v", err)
+	}
+	if len(*reqs) != 0 {
+		t.Errorf("Slack.Put() posts %d messages, want = 0", len(*reqs))
+	}
+}
@@ -0,0 +1,462 @@
+package cyqldog
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// ValidationError is an error of an item in the configuration file.
+type ValidationError struct {
+	// Path is a location of the item in the yaml. (e.g. rules[0].value_cols)
+	Path string
+	// Message describes what is wrong.
+	Message string
+}
+
+// Error implements the error interface.
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationErrors is a list of all errors found in the configuration file.
+type ValidationErrors []ValidationError
+
+// Error implements the error interface.
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, ve := range e {
+		msgs = append(msgs, ve.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// add appends a validation error.
+func (e *ValidationErrors) add(path string, format string, a ...interface{}) {
+	*e = append(*e, ValidationError{Path: path, Message: fmt.Sprintf(format, a...)})
+}
+
+// Validate checks the configuration and returns ValidationErrors
+// which collect every error found, or nil if there is no error.
+func (c *Config) Validate() error {
+	errs := ValidationErrors{}
+
+	dataSources := c.validateDataSources(&errs)
+	notifiers := c.validateNotifiers(&errs)
+	c.validateRules(dataSources, notifiers, &errs)
+	c.validateReadOnly(&errs)
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateRules checks the rules with the names of the data sources and the types of the notifiers by name.
+func (c *Config) validateRules(dataSources map[string]bool, notifiers map[string]string, errs *ValidationErrors) {
+	if c.Timeout < 0 {
+		errs.add("timeout", "must not be negative: %s", c.Timeout)
+	}
+	if c.ErrorEvents.RepeatInterval < 0 {
+		errs.add("error_events.repeat_interval", "must not be negative: %s", c.ErrorEvents.RepeatInterval)
+	}
+	if c.ErrorEvents.Retries < 0 {
+		errs.add("error_events.retries", "must not be negative: %d", c.ErrorEvents.Retries)
+	}
+	if c.ErrorEvents.Backoff < 0 {
+		errs.add("error_events.backoff", "must not be negative: %s", c.ErrorEvents.Backoff)
+	}
+	if f := c.ErrorEvents.Fallback; len(f) > 0 {
+		if _, ok := notifiers[f]; !ok {
+			errs.add("error_events.fallback", "unknown notifier: %s", f)
+		}
+	}
+
+	if len(c.Rules) == 0 {
+		errs.add("rules", "at least one rule is required")
+	}
+
+	names := make(map[string]int)
+	for i, r := range c.Rules {
+		path := fmt.Sprintf("rules[%d]", i)
+		r.validate(path, dataSources, notifiers, errs)
+
+		if j, ok := names[r.Name]; ok && len(r.Name) > 0 {
+			errs.add(path+".name", "duplicate rule name %q, already used by rules[%d]", r.Name, j)
+		} else {
+			names[r.Name] = i
+		}
+	}
+}
+
+// validateReadOnly checks the queries of the rules which query the read-only data sources.
+func (c *Config) validateReadOnly(errs *ValidationErrors) {
+	dsc, err := c.dataSourcesConfig()
+	if err != nil {
+		// It is reported by validateDataSources.
+		return
+	}
+
+	for i, r := range c.Rules {
+		ds := dsc[r.dataSourceName()]
+		if !ds.ReadOnly {
+			continue
+		}
+		if err := checkReadOnlyQuery(r.Query, ds.Driver); err != nil {
+			errs.add(fmt.Sprintf("rules[%d].query", i), "%s", err)
+		}
+	}
+}
+
+// validateDataSources checks the data sources and returns their names.
+func (c *Config) validateDataSources(errs *ValidationErrors) map[string]bool {
+	names := make(map[string]bool)
+
+	if len(c.DB.Driver) > 0 {
+		c.DB.validate("data_source", errs)
+		names[defaultDataSourceName] = true
+	}
+
+	// Sort names to report errors in a stable order.
+	keys := make([]string, 0, len(c.DataSources))
+	for name := range c.DataSources {
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+
+	for _, name := range keys {
+		path := "data_sources." + name
+		if name == defaultDataSourceName && names[defaultDataSourceName] {
+			errs.add(path, "data source %s is defined in both data_source and data_sources", defaultDataSourceName)
+		}
+
+		ds := c.DataSources[name]
+		ds.validate(path, errs)
+		names[name] = true
+	}
+
+	if len(names) == 0 {
+		errs.add("data_source", "at least one data source is required")
+	}
+
+	return names
+}
+
+// validateNotifiers checks the notifiers and returns the types of them by name.
+func (c *Config) validateNotifiers(errs *ValidationErrors) map[string]string {
+	types := make(map[string]string)
+
+	for _, name := range c.Notifiers.names() {
+		nc := c.Notifiers[name]
+		nc.validate("notifiers."+name, errs)
+		types[name] = nc.Type
+	}
+
+	if len(types) == 0 {
+		errs.add("notifiers", "at least one notifier is required")
+	}
+
+	return types
+}
+
+// validate checks the configuration of the notifier of the type.
+func (c NotifierConfig) validate(path string, errs *ValidationErrors) {
+	switch c.Type {
+	case NotifierDogstatsd:
+		c.Dogstatsd.validate(path, errs)
+	case NotifierPrometheus:
+		c.Prometheus.validate(path, errs)
+	case NotifierSlack:
+		c.Slack.validate(path, errs)
+	case NotifierWebhook:
+		c.Webhook.validate(path, errs)
+	default:
+		if _, ok := lookupNotifier(c.Type); !ok {
+			errs.add(path+".type", "unknown notifier type %q, must be one of %s", c.Type, strings.Join(allNotifierTypes(), ", "))
+		}
+	}
+}
+
+// validate checks the configuration of the dogstatsd.
+func (c DogstatsdConfig) validate(path string, errs *ValidationErrors) {
+	if len(c.Host) == 0 && len(c.Port) == 0 {
+		errs.add(path, "host or port is required")
+	}
+}
+
+// validate checks the configuration of the prometheus exporter.
+func (c PrometheusConfig) validate(path string, errs *ValidationErrors) {
+	if len(c.ListenAddress) == 0 {
+		errs.add(path+".listen_address", "is required")
+	}
+}
+
+// validate checks the configuration of the data source.
+func (s *DataSourceConfig) validate(path string, errs *ValidationErrors) {
+	supported := false
+	for _, d := range allDrivers() {
+		if s.Driver == d {
+			supported = true
+		}
+	}
+	if !supported {
+		errs.add(path+".driver", "unsupported database driver %q, must be one of %s", s.Driver, strings.Join(allDrivers(), ", "))
+	}
+
+	if s.MaxConcurrency < 0 {
+		errs.add(path+".max_concurrency", "must not be negative: %d", s.MaxConcurrency)
+	}
+	if s.MaxOpenConns < 0 {
+		errs.add(path+".max_open_conns", "must not be negative: %d", s.MaxOpenConns)
+	}
+	if s.MaxIdleConns < 0 {
+		errs.add(path+".max_idle_conns", "must not be negative: %d", s.MaxIdleConns)
+	}
+	// A connection is needed besides the running queries to kill them on MySQL and to ping.
+	if s.MaxOpenConns > 0 && s.MaxOpenConns <= s.maxConcurrency() {
+		errs.add(path+".max_open_conns", "must be greater than max_concurrency: %d <= %d", s.MaxOpenConns, s.maxConcurrency())
+	}
+	if s.MaxOpenConns > 0 && s.MaxIdleConns > s.MaxOpenConns {
+		errs.add(path+".max_idle_conns", "must not be greater than max_open_conns: %d > %d", s.MaxIdleConns, s.MaxOpenConns)
+	}
+	if s.ConnMaxLifetime < 0 {
+		errs.add(path+".conn_max_lifetime", "must not be negative: %s", s.ConnMaxLifetime)
+	}
+	if s.ConnMaxIdleTime < 0 {
+		errs.add(path+".conn_max_idle_time", "must not be negative: %s", s.ConnMaxIdleTime)
+	}
+	if s.ConnectBackoff < 0 {
+		errs.add(path+".connect_backoff", "must not be negative: %s", s.ConnectBackoff)
+	}
+	if s.ConnectMaxBackoff < 0 {
+		errs.add(path+".connect_max_backoff", "must not be negative: %s", s.ConnectMaxBackoff)
+	}
+	if s.HealthCheckInterval < 0 {
+		errs.add(path+".health_check_interval", "must not be negative: %s", s.HealthCheckInterval)
+	}
+}
+
+// validate checks the configuration of the webhook.
+func (c WebhookConfig) validate(path string, errs *ValidationErrors) {
+	if len(c.URL) == 0 {
+		errs.add(path+".url", "is required")
+	} else if u, err := url.Parse(c.URL); err != nil {
+		errs.add(path+".url", "%s", err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		errs.add(path+".url", "unsupported scheme %q, must be http or https", u.Scheme)
+	}
+
+	if _, err := c.body(); err != nil {
+		errs.add(path+".body", "%s", xerrors.Unwrap(err))
+	}
+
+	if c.Retries < 0 {
+		errs.add(path+".retries", "must not be negative: %d", c.Retries)
+	}
+	if c.Backoff < 0 {
+		errs.add(path+".backoff", "must not be negative: %s", c.Backoff)
+	}
+	if c.Timeout < 0 {
+		errs.add(path+".timeout", "must not be negative: %s", c.Timeout)
+	}
+}
+
+// validate checks the configuration of the slack.
+func (c SlackConfig) validate(path string, errs *ValidationErrors) {
+	if len(c.WebhookURL) == 0 {
+		errs.add(path+".webhook_url", "is required")
+	} else if u, err := url.Parse(c.WebhookURL); err != nil {
+		errs.add(path+".webhook_url", "%s", err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		errs.add(path+".webhook_url", "unsupported scheme %q, must be http or https", u.Scheme)
+	}
+
+	if c.RateLimit < 0 {
+		errs.add(path+".rate_limit", "must not be negative: %s", c.RateLimit)
+	}
+	if c.Retries < 0 {
+		errs.add(path+".retries", "must not be negative: %d", c.Retries)
+	}
+	if c.Timeout < 0 {
+		errs.add(path+".timeout", "must not be negative: %s", c.Timeout)
+	}
+}
+
+// validate checks the rule.
+// The notifiers are the types of the notifiers by name.
+func (r Rule) validate(path string, dataSources map[string]bool, notifiers map[string]string, errs *ValidationErrors) {
+	if len(r.Name) == 0 {
+		errs.add(path+".name", "is required")
+	}
+
+	if len(strings.TrimSpace(r.Query)) == 0 {
+		errs.add(path+".query", "is required")
+	}
+
+	if len(r.ValueCols) == 0 {
+		errs.add(path+".value_cols", "at least one column is required")
+	}
+
+	switch {
+	case len(r.Schedule) > 0 && r.Interval != 0:
+		errs.add(path, "both interval and schedule are set")
+	case len(r.Schedule) > 0:
+		if _, err := r.cronSchedule(); err != nil {
+			errs.add(path+".schedule", "%s", err)
+		}
+	case r.Interval <= 0:
+		errs.add(path+".interval", "must be positive: %s", r.Interval)
+	}
+
+	// Sort columns to report errors in a stable order.
+	cols := make([]string, 0, len(r.ValueTypes))
+	for col := range r.ValueTypes {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	for _, col := range cols {
+		r.ValueTypes[col].validate(path+".value_types."+col, col, r.ValueCols, errs)
+	}
+
+	switch r.NullValue.policy() {
+	case NullValueFail, NullValueSkip, NullValueDefault:
+	default:
+		errs.add(path+".null_value.policy", "unknown policy %q, must be one of %s, %s, %s", r.NullValue.Policy, NullValueFail, NullValueSkip, NullValueDefault)
+	}
+
+	for j, a := range r.Alerts {
+		a.validate(fmt.Sprintf("%s.alerts[%d]", path, j), r.ValueCols, errs)
+	}
+
+	if r.Timeout < 0 {
+		errs.add(path+".timeout", "must not be negative: %s", r.Timeout)
+	}
+
+	if !dataSources[r.dataSourceName()] {
+		errs.add(path+".data_source", "unknown data source %q", r.dataSourceName())
+	}
+
+	switch {
+	case len(r.Notifier) > 0 && len(r.Notifiers) > 0:
+		errs.add(path, "both notifier and notifiers are set")
+	case len(r.Notifiers) > 0:
+		seen := make(map[string]bool)
+		for j, name := range r.Notifiers {
+			if seen[name] {
+				errs.add(fmt.Sprintf("%s.notifiers[%d]", path, j), "duplicate notifier %q", name)
+			} else if len(notifiers[name]) == 0 {
+				errs.add(fmt.Sprintf("%s.notifiers[%d]", path, j), "unknown notifier %q", name)
+			}
+			seen[name] = true
+		}
+	case len(r.Notifier) == 0:
+		errs.add(path+".notifier", "is required")
+	case len(notifiers[r.Notifier]) == 0:
+		errs.add(path+".notifier", "unknown notifier %q", r.Notifier)
+	}
+
+	// The other notifiers send all metrics as gauges without sampling.
+	for _, name := range r.notifierNames() {
+		if t := notifiers[name]; len(t) == 0 || t == NotifierDogstatsd {
+			continue
+		}
+		for _, col := range cols {
+			vt := r.ValueTypes[col]
+			if len(vt.Type) > 0 && vt.Type != MetricGauge {
+				errs.add(path+".value_types."+col+".type", "%s is supported only by the dogstatsd notifier, but notifier %q is %s", vt.Type, name, notifiers[name])
+			}
+			if vt.SampleRate > 0 && vt.SampleRate < 1 {
+				errs.add(path+".value_types."+col+".sample_rate", "is supported only by the dogstatsd notifier, but notifier %q is %s", name, notifiers[name])
+			}
+		}
+		break
+	}
+
+	if len(r.Channel) > 0 {
+		slack := false
+		for _, name := range r.notifierNames() {
+			if notifiers[name] == NotifierSlack {
+				slack = true
+			}
+		}
+		if !slack {
+			errs.add(path+".channel", "is supported only by the slack notifier")
+		}
+	}
+}
+
+// validate checks the metric type of the value column.
+func (t ValueType) validate(path string, col string, valueCols []string, errs *ValidationErrors) {
+	found := false
+	for _, vc := range valueCols {
+		if col == vc {
+			found = true
+		}
+	}
+	if !found {
+		errs.add(path, "%q is not one of value_cols", col)
+	}
+
+	supported := len(t.Type) == 0
+	for _, mt := range metricTypes {
+		if t.Type == mt {
+			supported = true
+		}
+	}
+	if !supported {
+		errs.add(path+".type", "unknown metric type %q, must be one of %s", t.Type, strings.Join(metricTypes, ", "))
+	}
+
+	if t.SampleRate < 0 || t.SampleRate > 1 {
+		errs.add(path+".sample_rate", "must be between 0 and 1: %v", t.SampleRate)
+	}
+}
+
+// validate checks the alert.
+func (a Alert) validate(path string, valueCols []string, errs *ValidationErrors) {
+	found := false
+	for _, vc := range valueCols {
+		if a.ValueCol == vc {
+			found = true
+		}
+	}
+	if !found {
+		errs.add(path+".value_col", "%q is not one of value_cols", a.ValueCol)
+	}
+
+	switch a.direction() {
+	case AlertAbove, AlertBelow:
+	default:
+		errs.add(path+".direction", "unknown direction %q, must be %s or %s", a.Direction, AlertAbove, AlertBelow)
+		return
+	}
+
+	if a.Warning == nil && a.Critical == nil {
+		errs.add(path, "at least one of warning or critical is required")
+		return
+	}
+
+	// The critical threshold must be beyond the warning threshold,
+	// and the recovery threshold must not be beyond the lower one.
+	if a.Warning != nil && a.Critical != nil && a.crosses(*a.Warning, a.Critical) {
+		errs.add(path+".critical", "must not be %s the warning threshold", oppositeDirection(a.direction()))
+	}
+	lower := a.Warning
+	if lower == nil {
+		lower = a.Critical
+	}
+	if a.Recovery != nil && a.crosses(*a.Recovery, lower) {
+		errs.add(path+".recovery", "must not be %s the thresholds", a.direction())
+	}
+}
+
+// oppositeDirection returns the opposite direction of alerts.
+func oppositeDirection(d string) string {
+	if d == AlertBelow {
+		return AlertAbove
+	}
+	return AlertBelow
+}
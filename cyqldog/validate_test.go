@@ -0,0 +1,325 @@
+package cyqldog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+func TestConfigValidate(t *testing.T) {
+	validRule := func(name string) Rule {
+		return Rule{
+			Name:      name,
+			Interval:  5 * time.Second,
+			Query:     "SELECT COUNT(*) AS count FROM table1",
+			Notifier:  "dogstatsd",
+			ValueCols: []string{"count"},
+		}
+	}
+	notifiers := NotifiersConfig{
+		"dogstatsd": {Type: NotifierDogstatsd, Dogstatsd: DogstatsdConfig{Host: "localhost", Port: "8125"}},
+	}
+
+	cases := []struct {
+		name   string
+		config Config
+		paths  []string
+	}{
+		{
+			name: "valid",
+			config: Config{
+				DB:        DataSourceConfig{Driver: "postgres"},
+				Notifiers: notifiers,
+				Rules:     []Rule{validRule("test1"), validRule("test2")},
+			},
+			paths: nil,
+		},
+		{
+			name: "no data source",
+			config: Config{
+				Notifiers: notifiers,
+				Rules:     []Rule{validRule("test1")},
+			},
+			paths: []string{"data_source", "rules[0].data_source"},
+		},
+		{
+			name: "data sources",
+			config: Config{
+				DB: DataSourceConfig{Driver: "oracle"},
+				DataSources: DataSourcesConfig{
+					"default": DataSourceConfig{Driver: "mysql"},
+					"replica": DataSourceConfig{Driver: "mysql", MaxConcurrency: -1, MaxOpenConns: 2, MaxIdleConns: 5, ConnMaxLifetime: -time.Second, ConnectBackoff: -time.Second, HealthCheckInterval: -time.Second},
+					"small":   DataSourceConfig{Driver: "mysql", MaxConcurrency: 2, MaxOpenConns: 2},
+				},
+				Notifiers: notifiers,
+				Rules:     []Rule{validRule("test1")},
+			},
+			paths: []string{"data_source.driver", "data_sources.default", "data_sources.replica.max_concurrency", "data_sources.replica.max_idle_conns", "data_sources.replica.conn_max_lifetime", "data_sources.replica.connect_backoff", "data_sources.replica.health_check_interval", "data_sources.small.max_open_conns"},
+		},
+		{
+			name: "value types",
+			config: Config{
+				DB:        DataSourceConfig{Driver: "postgres"},
+				Notifiers: notifiers,
+				Rules: []Rule{
+					{
+						Name:      "test1",
+						Interval:  5 * time.Second,
+						Query:     "SELECT 1 AS count, 2 AS latency",
+						Notifier:  "dogstatsd",
+						ValueCols: []string{"count", "latency"},
+						ValueTypes: map[string]ValueType{
+							"count":   {Type: MetricCount},
+							"latency": {Type: "timer", SampleRate: 1.5},
+							"unknown": {Type: MetricSet},
+						},
+					},
+				},
+			},
+			paths: []string{"rules[0].value_types.latency.type", "rules[0].value_types.latency.sample_rate", "rules[0].value_types.unknown"},
+		},
+		{
+			name: "value types for other notifiers",
+			config: Config{
+				DB: DataSourceConfig{Driver: "postgres"},
+				Notifiers: NotifiersConfig{
+					"dogstatsd":  {Type: NotifierDogstatsd, Dogstatsd: DogstatsdConfig{Host: "localhost", Port: "8125"}},
+					"prometheus": {Type: NotifierPrometheus, Prometheus: PrometheusConfig{ListenAddress: ":9187"}},
+				},
+				Rules: []Rule{
+					{
+						Name:      "test1",
+						Interval:  5 * time.Second,
+						Query:     "SELECT 1 AS count, 2 AS latency, 3 AS total",
+						Notifiers: []string{"dogstatsd", "prometheus"},
+						ValueCols: []string{"count", "latency", "total"},
+						ValueTypes: map[string]ValueType{
+							"count":   {Type: MetricCount},
+							"latency": {Type: MetricGauge, SampleRate: 0.5},
+							"total":   {Type: MetricGauge, SampleRate: 1},
+						},
+					},
+				},
+			},
+			paths: []string{"rules[0].value_types.count.type", "rules[0].value_types.latency.sample_rate"},
+		},
+		{
+			name: "notifier types",
+			config: Config{
+				DB: DataSourceConfig{Driver: "postgres"},
+				Notifiers: NotifiersConfig{
+					"dogstatsd": {Type: NotifierDogstatsd, Dogstatsd: DogstatsdConfig{Host: "localhost", Port: "8125"}},
+					"analytics": {Type: NotifierDogstatsd},
+					"datadog":   {Type: "datadog"},
+				},
+				Rules: []Rule{validRule("test1")},
+			},
+			paths: []string{"notifiers.analytics", "notifiers.datadog.type"},
+		},
+		{
+			name: "notifiers",
+			config: Config{
+				DB: DataSourceConfig{Driver: "postgres"},
+				Notifiers: NotifiersConfig{
+					"dogstatsd":  {Type: NotifierDogstatsd, Dogstatsd: DogstatsdConfig{Host: "localhost", Port: "8125"}},
+					"prometheus": {Type: NotifierPrometheus, Prometheus: PrometheusConfig{ListenAddress: ":9187"}},
+				},
+				Rules: []Rule{
+					{
+						Name:      "test1",
+						Interval:  5 * time.Second,
+						Query:     "SELECT 1 AS count",
+						Notifiers: []string{"dogstatsd", "prometheus"},
+						ValueCols: []string{"count"},
+					},
+					{
+						Name:      "test2",
+						Interval:  5 * time.Second,
+						Query:     "SELECT 1 AS count",
+						Notifiers: []string{"dogstatsd", "unknown", "dogstatsd"},
+						ValueCols: []string{"count"},
+					},
+					{
+						Name:      "test3",
+						Interval:  5 * time.Second,
+						Query:     "SELECT 1 AS count",
+						Notifier:  "dogstatsd",
+						Notifiers: []string{"prometheus"},
+						ValueCols: []string{"count"},
+					},
+				},
+			},
+			paths: []string{"rules[1].notifiers[1]", "rules[1].notifiers[2]", "rules[2]"},
+		},
+		{
+			name: "slack",
+			config: Config{
+				DB: DataSourceConfig{Driver: "postgres"},
+				Notifiers: NotifiersConfig{
+					"dogstatsd": {Type: NotifierDogstatsd, Dogstatsd: DogstatsdConfig{Host: "localhost", Port: "8125"}},
+					"slack":     {Type: NotifierSlack, Slack: SlackConfig{WebhookURL: "hooks.slack.com", RateLimit: -1}},
+				},
+				Rules: []Rule{
+					{
+						Name:      "test1",
+						Interval:  5 * time.Second,
+						Query:     "SELECT 1 AS count",
+						Notifier:  "slack",
+						Channel:   "#db",
+						ValueCols: []string{"count"},
+					},
+					{
+						Name:      "test2",
+						Interval:  5 * time.Second,
+						Query:     "SELECT 1 AS count",
+						Notifier:  "dogstatsd",
+						Channel:   "#db",
+						ValueCols: []string{"count"},
+					},
+				},
+			},
+			paths: []string{"notifiers.slack.webhook_url", "notifiers.slack.rate_limit", "rules[1].channel"},
+		},
+		{
+			name: "webhook",
+			config: Config{
+				DB: DataSourceConfig{Driver: "postgres"},
+				Notifiers: NotifiersConfig{
+					"webhook": {Type: NotifierWebhook, Webhook: WebhookConfig{URL: "ftp://example.com", Body: "{{ .Title", Retries: -1}},
+				},
+				Rules: []Rule{validRule("test1")},
+			},
+			paths: []string{"notifiers.webhook.url", "notifiers.webhook.body", "notifiers.webhook.retries", "rules[0].notifier"},
+		},
+		{
+			name: "rules",
+			config: Config{
+				DB:        DataSourceConfig{Driver: "postgres"},
+				Notifiers: notifiers,
+				Rules: []Rule{
+					validRule("test1"),
+					{
+						Name:       "test1",
+						Interval:   0,
+						Notifier:   "unknown",
+						DataSource: "unknown",
+						Timeout:    -1,
+					},
+					{
+						Name:      "test3",
+						Interval:  5 * time.Second,
+						Schedule:  "0 0 * * *",
+						Query:     "SELECT 1 AS count",
+						ValueCols: []string{"count"},
+					},
+					{
+						Name:      "test4",
+						Schedule:  "0 0 * *",
+						Query:     "SELECT 1 AS count",
+						Notifier:  "dogstatsd",
+						ValueCols: []string{"count"},
+					},
+				},
+			},
+			paths: []string{
+				"rules[1].query",
+				"rules[1].value_cols",
+				"rules[1].interval",
+				"rules[1].timeout",
+				"rules[1].data_source",
+				"rules[1].notifier",
+				"rules[1].name",
+				"rules[2]",
+				"rules[2].notifier",
+				"rules[3].schedule",
+			},
+		},
+		{
+			name: "alerts",
+			config: Config{
+				DB:        DataSourceConfig{Driver: "postgres"},
+				Notifiers: notifiers,
+				Rules: []Rule{
+					{
+						Name:      "test1",
+						Interval:  5 * time.Second,
+						Query:     "SELECT COUNT(*) AS count FROM table1",
+						Notifier:  "dogstatsd",
+						ValueCols: []string{"count"},
+						Alerts: []Alert{
+							{ValueCol: "count", Warning: float64Ptr(500), Critical: float64Ptr(1000), Recovery: float64Ptr(400)},
+							{ValueCol: "unknown", Direction: AlertBelow, Critical: float64Ptr(1)},
+							{ValueCol: "count", Direction: "sideways", Critical: float64Ptr(1)},
+							{ValueCol: "count"},
+							{ValueCol: "count", Warning: float64Ptr(1000), Critical: float64Ptr(500)},
+							{ValueCol: "count", Direction: AlertBelow, Critical: float64Ptr(10), Recovery: float64Ptr(5)},
+						},
+					},
+				},
+			},
+			paths: []string{
+				"rules[0].alerts[1].value_col",
+				"rules[0].alerts[2].direction",
+				"rules[0].alerts[3]",
+				"rules[0].alerts[4].critical",
+				"rules[0].alerts[5].recovery",
+			},
+		},
+		{
+			name: "read only",
+			config: Config{
+				DB: DataSourceConfig{Driver: "postgres"},
+				DataSources: DataSourcesConfig{
+					"replica": DataSourceConfig{Driver: "postgres", ReadOnly: true},
+				},
+				Notifiers: notifiers,
+				Rules: []Rule{
+					{Name: "test1", Interval: time.Second, Query: "DELETE FROM table1", Notifier: "dogstatsd", ValueCols: []string{"count"}},
+					{Name: "test2", Interval: time.Second, Query: "DELETE FROM table1", Notifier: "dogstatsd", ValueCols: []string{"count"}, DataSource: "replica"},
+				},
+			},
+			paths: []string{"rules[1].query"},
+		},
+		{
+			name: "error events",
+			config: Config{
+				DB:          DataSourceConfig{Driver: "postgres"},
+				Notifiers:   notifiers,
+				ErrorEvents: ErrorEventsConfig{RepeatInterval: -time.Minute, Retries: -1, Backoff: -time.Second, Fallback: "unknown"},
+				Rules:       []Rule{validRule("test1")},
+			},
+			paths: []string{"error_events.repeat_interval", "error_events.retries", "error_events.backoff", "error_events.fallback"},
+		},
+		{
+			name: "no rules",
+			config: Config{
+				DB:        DataSourceConfig{Driver: "postgres"},
+				Notifiers: notifiers,
+			},
+			paths: []string{"rules"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.config.Validate()
+
+			var paths []string
+			if err != nil {
+				var errs ValidationErrors
+				if !xerrors.As(err, &errs) {
+					t.Fatalf("Config.Validate() returns err = %+v, want ValidationErrors", err)
+				}
+				for _, ve := range errs {
+					paths = append(paths, ve.Path)
+				}
+			}
+
+			if !reflect.DeepEqual(paths, tc.paths) {
+				t.Errorf("Config.Validate() returns errors on\n got = %v,\nwant = %v\nerr = %v", paths, tc.paths, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package cyqldog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// WebhookConfig is a configuration of the webhook to post events to.
+type WebhookConfig struct {
+	// URL is an endpoint of the webhook.
+	URL string `yaml:"url"`
+	// Method is a HTTP method of the request. (default: POST)
+	Method string `yaml:"method"`
+	// Headers are HTTP headers added to the request.
+	// If Content-Type is not set, application/json is used.
+	Headers map[string]string `yaml:"headers"`
+	// Body is a Go template of the request body of an event.
+	// If empty, the event is posted as JSON.
+	Body string `yaml:"body"`
+	// Metrics posts the metrics of the rules as JSON.
+	// If false, the metrics are ignored.
+	Metrics bool `yaml:"metrics"`
+	// Retries is the number of retries of failed requests.
+	Retries int `yaml:"retries"`
+	// Backoff is a wait before the first retry, which doubles on each retry. (default: 1s)
+	Backoff time.Duration `yaml:"backoff"`
+	// Timeout is a timeout of each request. (default: 10s)
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Default values of the webhook.
+const (
+	defaultWebhookMethod  = http.MethodPost
+	defaultWebhookBackoff = time.Second
+	defaultWebhookTimeout = 10 * time.Second
+)
+
+// method returns the method with the default applied.
+func (c WebhookConfig) method() string {
+	if len(c.Method) == 0 {
+		return defaultWebhookMethod
+	}
+	return strings.ToUpper(c.Method)
+}
+
+// backoff returns the backoff with the default applied.
+func (c WebhookConfig) backoff() time.Duration {
+	if c.Backoff == 0 {
+		return defaultWebhookBackoff
+	}
+	return c.Backoff
+}
+
+// timeout returns the timeout with the default applied.
+func (c WebhookConfig) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return defaultWebhookTimeout
+	}
+	return c.Timeout
+}
+
+// webhookFuncs are functions available in the body template.
+var webhookFuncs = template.FuncMap{
+	// json encodes the value as JSON, so that strings can be embedded in JSON bodies safely.
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// body returns the parsed body template, or nil if the body is empty.
+func (c WebhookConfig) body() (*template.Template, error) {
+	if len(c.Body) == 0 {
+		return nil, nil
+	}
+
+	tmpl, err := template.New("body").Funcs(webhookFuncs).Parse(c.Body)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to parse webhook body: %w", err)
+	}
+	return tmpl, nil
+}
+
+// Webhook is a notifier which posts events to a HTTP endpoint.
+type Webhook struct {
+	config WebhookConfig
+	body   *template.Template
+	client *http.Client
+}
+
+// newWebhook returns an instance of Notifier interface.
+func newWebhook(c WebhookConfig) (Notifier, error) {
+	body, err := c.body()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Webhook{
+		config: c,
+		body:   body,
+		client: &http.Client{Timeout: c.timeout()},
+	}, nil
+}
+
+// webhookEvent is the default JSON body of an event.
+type webhookEvent struct {
+	Title string   `json:"title"`
+	Text  string   `json:"text"`
+	Level string   `json:"level"`
+	Tags  []string `json:"tags"`
+}
+
+// webhookMetrics is the JSON body of the metrics of a rule.
+type webhookMetrics struct {
+	Rule    string          `json:"rule"`
+	Metrics []webhookMetric `json:"metrics"`
+}
+
+// webhookMetric is a metric in the JSON body.
+type webhookMetric struct {
+	Name  string   `json:"name"`
+	Value float64  `json:"value"`
+	Tags  []string `json:"tags"`
+}
+
+// Event posts the event to the webhook.
+func (w *Webhook) Event(ctx context.Context, e *Event) error {
+	level := e.Level
+	if len(level) == 0 {
+		level = "info"
+	}
+	tags := e.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	event := webhookEvent{Title: e.Title, Text: e.Text, Level: level, Tags: tags}
+
+	var body []byte
+	if w.body == nil {
+		b, err := json.Marshal(event)
+		if err != nil {
+			return xerrors.Errorf("failed to encode event: %w", err)
+		}
+		body = b
+	} else {
+		var buf bytes.Buffer
+		if err := w.body.Execute(&buf, event); err != nil {
+			return xerrors.Errorf("failed to render webhook body: %w", err)
+		}
+		body = buf.Bytes()
+	}
+
+	return w.post(ctx, body)
+}
+
+// Put posts the metrics of the query result as JSON if metrics is enabled.
+func (w *Webhook) Put(ctx context.Context, qr QueryResult, rule Rule) error {
+	if !w.config.Metrics {
+		return nil
+	}
+
+	metrics, err := buildMetricsForQueryResult(qr, rule)
+	if err != nil {
+		return err
+	}
+
+	wm := webhookMetrics{Rule: rule.Name, Metrics: make([]webhookMetric, 0, len(metrics))}
+	for _, m := range metrics {
+		wm.Metrics = append(wm.Metrics, webhookMetric{Name: m.name, Value: m.value, Tags: m.tags})
+	}
+	body, err := json.Marshal(wm)
+	if err != nil {
+		return xerrors.Errorf("failed to encode metrics: rule = %s: %w", rule.Name, err)
+	}
+
+	return w.post(ctx, body)
+}
+
+// post sends the body to the webhook.
+// Failed requests are retried with exponential backoff, except client errors.
+func (w *Webhook) post(ctx context.Context, body []byte) error {
+	backoff := w.config.backoff()
+	for i := 0; ; i++ {
+		retryable, err := w.do(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retryable || i >= w.config.Retries {
+			return err
+		}
+
+		log.Printf("webhook: retry in %s: %+v", backoff, err)
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return xerrors.Errorf("failed to retry webhook: %w", ctx.Err())
+		}
+		backoff *= 2
+	}
+}
+
+// do sends a request to the webhook.
+// It returns whether the request can be retried if it fails.
+func (w *Webhook) do(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, w.config.method(), w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, xerrors.Errorf("failed to build webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, xerrors.Errorf("failed to request webhook: %s %s: %w", req.Method, w.config.URL, err)
+	}
+	defer res.Body.Close()
+	// Read the body to reuse the connection.
+	io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+
+	retryable := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+	return retryable, xerrors.Errorf("webhook returns unexpected status: %s %s: %s", req.Method, w.config.URL, res.Status)
+}
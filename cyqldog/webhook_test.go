@@ -0,0 +1,183 @@
+package cyqldog
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookRequest is a request received by the test server.
+type webhookRequest struct {
+	method string
+	header http.Header
+	body   string
+}
+
+// newWebhookServer returns a test server which responds with the statuses in order,
+// and records the requests received.
+func newWebhookServer(t *testing.T, statuses ...int) (*httptest.Server, *[]webhookRequest) {
+	t.Helper()
+
+	var mu sync.Mutex
+	reqs := []webhookRequest{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		defer mu.Unlock()
+		status := http.StatusOK
+		if len(reqs) < len(statuses) {
+			status = statuses[len(reqs)]
+		}
+		reqs = append(reqs, webhookRequest{method: r.Method, header: r.Header, body: string(body)})
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts, &reqs
+}
+
+func TestWebhookEvent(t *testing.T) {
+	event := &Event{Title: `cyqldog: "test1" failed`, Text: "detail", Level: "error", Tags: []string{"cyqldog"}}
+
+	cases := []struct {
+		name     string
+		config   WebhookConfig
+		statuses []int
+		method   string
+		body     string
+		header   map[string]string
+		requests int
+		wantErr  bool
+	}{
+		{
+			name:     "default",
+			config:   WebhookConfig{},
+			method:   http.MethodPost,
+			body:     `{"title":"cyqldog: \"test1\" failed","text":"detail","level":"error","tags":["cyqldog"]}`,
+			header:   map[string]string{"Content-Type": "application/json"},
+			requests: 1,
+		},
+		{
+			name: "template",
+			config: WebhookConfig{
+				Method:  "put",
+				Headers: map[string]string{"Authorization": "Bearer token", "Content-Type": "text/plain"},
+				Body:    `{"text": {{ json .Title }}, "severity": "{{ .Level }}"}`,
+			},
+			method:   http.MethodPut,
+			body:     `{"text": "cyqldog: \"test1\" failed", "severity": "error"}`,
+			header:   map[string]string{"Authorization": "Bearer token", "Content-Type": "text/plain"},
+			requests: 1,
+		},
+		{
+			name:     "retry",
+			config:   WebhookConfig{Retries: 2, Backoff: time.Millisecond},
+			statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK},
+			requests: 3,
+		},
+		{
+			name:     "retry exhausted",
+			config:   WebhookConfig{Retries: 1, Backoff: time.Millisecond},
+			statuses: []int{http.StatusInternalServerError, http.StatusInternalServerError},
+			requests: 2,
+			wantErr:  true,
+		},
+		{
+			name:     "client error",
+			config:   WebhookConfig{Retries: 2, Backoff: time.Millisecond},
+			statuses: []int{http.StatusBadRequest},
+			requests: 1,
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts, reqs := newWebhookServer(t, tc.statuses...)
+			tc.config.URL = ts.URL
+
+			w, err := newWebhook(tc.config)
+			if err != nil {
+				t.Fatalf("newWebhook() returns unexpected err = %+v", err)
+			}
+
+			err = w.Event(context.Background(), event)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Webhook.Event() returns err = %v, wantErr = %t", err, tc.wantErr)
+			}
+
+			if len(*reqs) != tc.requests {
+				t.Fatalf("Webhook.Event() sends %d requests, want = %d", len(*reqs), tc.requests)
+			}
+			req := (*reqs)[0]
+			if len(tc.method) > 0 && req.method != tc.method {
+				t.Errorf("Webhook.Event() sends method = %s, want = %s", req.method, tc.method)
+			}
+			if len(tc.body) > 0 && req.body != tc.body {
+				t.Errorf("Webhook.Event() sends body = %s, want = %s", req.body, tc.body)
+			}
+			for k, v := range tc.header {
+				if got := req.header.Get(k); got != v {
+					t.Errorf("Webhook.Event() sends header %s = %s, want = %s", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookPut(t *testing.T) {
+	rule := Rule{Name: "test1", TagCols: []string{"tag1"}, ValueCols: []string{"count"}}
+	qr := QueryResult{
+		Records: []Record{
+			{"tag1": Value{String: "foo"}, "count": Value{String: "3"}},
+		},
+	}
+
+	cases := []struct {
+		name     string
+		metrics  bool
+		requests []webhookRequest
+	}{
+		{
+			name:     "ignored",
+			metrics:  false,
+			requests: []webhookRequest{},
+		},
+		{
+			name:    "posted",
+			metrics: true,
+			requests: []webhookRequest{
+				{body: `{"rule":"test1","metrics":[{"name":"test1.count","value":3,"tags":["tag1:foo"]}]}`},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts, reqs := newWebhookServer(t)
+
+			w, err := newWebhook(WebhookConfig{URL: ts.URL, Metrics: tc.metrics})
+			if err != nil {
+				t.Fatalf("newWebhook() returns unexpected err = %+v", err)
+			}
+
+			if err := w.Put(context.Background(), qr, rule); err != nil {
+				t.Fatalf("Webhook.Put() returns unexpected err = %+v", err)
+			}
+
+			if len(*reqs) != len(tc.requests) {
+				t.Fatalf("Webhook.Put() sends %d requests, want = %d", len(*reqs), len(tc.requests))
+			}
+			for i, want := range tc.requests {
+				if got := (*reqs)[i].body; got != want.body {
+					t.Errorf("Webhook.Put() sends body = %s, want = %s", got, want.body)
+				}
+			}
+		})
+	}
+}
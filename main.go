@@ -2,11 +2,15 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"strings"
 
 	"github.com/crowdworks/cyqldog/cyqldog"
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
+	"golang.org/x/xerrors"
 )
 
 var (
@@ -17,17 +21,102 @@ var (
 )
 
 func main() {
+	// The subcommand is optional for backward compatibility.
+	// `cyqldog -C cyqldog.yml` is the same as `cyqldog run -C cyqldog.yml`.
+	cmd := "run"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd = args[0]
+		args = args[1:]
+	}
+
+	switch cmd {
+	case "run":
+		runCommand(args)
+	case "validate":
+		os.Exit(validateCommand(args))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\nusage: cyqldog [run|validate] [-C path/to/cyqldog.yml]\n", cmd)
+		os.Exit(2)
+	}
+}
+
+// runCommand starts monitoring.
+func runCommand(args []string) {
 	log.Printf("main: starting cyqldog (version: %s, commit: %s, date: %s)", version, commit, date)
 
 	// Parse the argument's flag.
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
 	var configPath string
-	flag.StringVar(&configPath, "C", "./cyqldog.yml", "path to config file")
-	flag.Parse()
+	fs.StringVar(&configPath, "C", "./cyqldog.yml", "path to config file")
+	var once bool
+	fs.BoolVar(&once, "once", false, "check each rule once and exit")
+	var rules ruleNames
+	fs.Var(&rules, "rule", "name of rule to check with -once (repeatable, default: all rules)")
+	var dryRun bool
+	fs.BoolVar(&dryRun, "dry-run", false, "print metrics and events to stdout instead of sending them")
+	var format string
+	fs.StringVar(&format, "format", cyqldog.PrintTable, "output format of -dry-run (table or json)")
+	fs.Parse(args)
+
+	if len(rules) > 0 && !once {
+		log.Fatal("main: -rule is available only with -once")
+	}
 
 	m := cyqldog.NewMonitor(configPath)
-	if err := m.Run(); err != nil {
+	if dryRun {
+		if err := m.DryRun(os.Stdout, format); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if once {
+		if err := m.RunOnce(rules); err != nil {
+			log.Fatal(err)
+		}
+	} else if err := m.Run(); err != nil {
 		log.Fatal(err)
 	}
 
 	log.Println("main: end")
 }
+
+// ruleNames is a flag which can be specified multiple times.
+type ruleNames []string
+
+// String implements the flag.Value interface.
+func (r *ruleNames) String() string {
+	return strings.Join(*r, ",")
+}
+
+// Set implements the flag.Value interface.
+func (r *ruleNames) Set(v string) error {
+	*r = append(*r, v)
+	return nil
+}
+
+// validateCommand checks the configuration file and returns the exit status.
+func validateCommand(args []string) int {
+	// Parse the argument's flag.
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	var configPath string
+	fs.StringVar(&configPath, "C", "./cyqldog.yml", "path to config file")
+	fs.Parse(args)
+
+	err := cyqldog.ValidateConfig(configPath)
+	if err == nil {
+		fmt.Printf("%s: ok\n", configPath)
+		return 0
+	}
+
+	// Print each validation error on its own line.
+	var errs cyqldog.ValidationErrors
+	if xerrors.As(err, &errs) {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", configPath, e)
+		}
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: %s\n", configPath, err)
+	return 1
+}